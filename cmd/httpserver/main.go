@@ -1,27 +1,127 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/JA50N14/httpfromtcp/internal/accesslog"
+	"github.com/JA50N14/httpfromtcp/internal/client"
 	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/ratelimit"
 	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/requestid"
 	"github.com/JA50N14/httpfromtcp/internal/response"
 	"github.com/JA50N14/httpfromtcp/internal/server"
+	"github.com/JA50N14/httpfromtcp/internal/sse"
+	"github.com/JA50N14/httpfromtcp/internal/tracing"
 )
 
 const port = 42069
 
+const proxyTimeout = 30 * time.Second
+
+const maxUploadSize = 10 << 20
+
+const traceShutdownTimeout = 5 * time.Second
+
+// Each client may burst proxyRateLimit requests to httpbin, then gets that many
+// per proxyRateWindow, so the proxy can't be used to hammer it.
+const (
+	proxyRateLimit  = 20
+	proxyRateWindow = time.Minute
+)
+
+const (
+	accessLogMaxSize = 100 << 20
+	accessLogBackups = 5
+)
+
+var proxyClient = &client.Client{}
+
 func main() {
-	server, err := server.Serve(port, handler)
+	certFile := flag.String("cert", "", "TLS certificate file, enables HTTPS and HTTP/2")
+	keyFile := flag.String("key", "", "TLS private key file")
+	h2c := flag.Bool("h2c", false, "accept cleartext HTTP/2 with prior knowledge")
+	accessLogPath := flag.String("access-log", "", "access log file, rotated by size (default stdout)")
+	accessLogFormat := flag.String("access-log-format", "combined", "access log format: common, combined or json")
+	metricsPath := flag.String("metrics-path", "/metrics", "path serving Prometheus metrics, empty to disable")
+	traceExporter := flag.String("trace-exporter", "none", "where to send trace spans: none, stdout or otlp")
+	otlpEndpoint := flag.String("otlp-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint for -trace-exporter=otlp")
+	flag.Parse()
+
+	format, err := accesslog.ParseFormat(*accessLogFormat)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	var accessLog io.Writer = os.Stdout
+	if *accessLogPath != "" {
+		f, err := accesslog.OpenRotatingFile(*accessLogPath, accessLogMaxSize, accessLogBackups)
+		if err != nil {
+			log.Fatalf("Error opening access log: %v\n", err)
+		}
+		defer f.Close()
+		accessLog = f
+	}
+
+	middleware := []server.Middleware{requestid.Middleware}
+	var exporter tracing.Exporter
+	switch *traceExporter {
+	case "none":
+	case "stdout":
+		exporter = tracing.NewStdoutExporter(os.Stdout)
+	case "otlp":
+		exporter = &tracing.OTLPExporter{Endpoint: *otlpEndpoint, ServiceName: "httpfromtcp"}
+	default:
+		log.Fatalf("Error: unknown trace exporter %q\n", *traceExporter)
+	}
+	if exporter != nil {
+		tracer := tracing.NewTracer(exporter)
+		//runs after server.Close, exporting the spans still queued
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+			defer cancel()
+			err := tracer.Shutdown(ctx)
+			if err != nil {
+				log.Printf("error shutting down tracer: %v", err)
+			}
+		}()
+		middleware = append(middleware, tracing.Middleware(tracer))
+	}
+
+	opts := []server.Option{}
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v\n", err)
+		}
+		opts = append(opts, server.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	}
+	if *h2c {
+		opts = append(opts, server.WithH2C())
+	}
+
+	if *metricsPath != "" {
+		opts = append(opts, server.WithMetrics(*metricsPath))
+	}
+	opts = append(opts, server.WithMaxBodySize(maxUploadSize))
+	opts = append(opts, server.WithAccessLog(accesslog.Logger(accessLog, format)))
+	errorPages()
+
+	handler := server.Chain(routes().Serve, middleware...)
+	server, err := server.Serve(port, handler, opts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v\n", err)
 	}
@@ -34,31 +134,59 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
-		return
-	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
-		videoHandler(w, req)
-		return
-	}
+func routes() *server.Mux {
+	mux := server.NewMux()
+	mux.Handle("GET", "/httpbin", redirectHandler("/httpbin/"))
+	mux.Handle("GET", "/httpbin/", proxyLimiter().Middleware(proxyHandler))
+	mux.Handle("GET", "/video", videoHandler)
+	mux.Handle("GET", "/video/", videoHandler)
+	mux.Handle("GET", "/events", eventsHandler)
+	mux.Handle("POST", "/upload", uploadHandler)
+	mux.Handle("PUT", "/upload", uploadHandler)
+	mux.Handle("GET", "/yourproblem", handler400)
+	mux.Handle("GET", "/myproblem", handler500)
+	mux.Handle("GET", "/", handler200)
+	return mux
+}
 
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
-		return
+func proxyLimiter() *ratelimit.Limiter {
+	l, err := ratelimit.New(ratelimit.Options{
+		Algorithm: ratelimit.TokenBucket,
+		Limit:     proxyRateLimit,
+		Window:    proxyRateWindow,
+	})
+	if err != nil {
+		log.Fatalf("Error creating rate limiter: %v\n", err)
 	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
-		return
+	return l
+}
+
+// redirectHandler sends clients to path, keeping their query.
+func redirectHandler(path string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		location := path
+		if req.Target.RawQuery != "" {
+			location += "?" + req.Target.RawQuery
+		}
+		w.WriteStatusLine(response.StatusCodePermanentRedirect)
+		h := response.GetDefaultHeaders(0)
+		h.Override("Location", location)
+		w.WriteHeaders(h)
 	}
-	handler200(w, req)
-	return
 }
 
-func handler400(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeBadRequest)
-	body := []byte(`<html>
+func handler400(w *response.Writer, req *request.Request) {
+	server.Error(w, req, response.StatusCodeBadRequest, "")
+}
+
+func handler500(w *response.Writer, req *request.Request) {
+	server.Error(w, req, response.StatusCodeInternalServerError, "")
+}
+
+// errorPages gives browsers our own pages for the errors the demo routes show off.
+func errorPages() {
+	server.DefaultErrorPages.Handle(response.StatusCodeBadRequest, "text/html", func(server.Problem) []byte {
+		return []byte(`<html>
   <head>
     <title>400 Bad Request</title>
   </head>
@@ -67,16 +195,9 @@ func handler400(w *response.Writer, _ *request.Request) {
     <p>Your request honestly kinda sucked.</p>
   </body>
 </html>`)
-	headers := response.GetDefaultHeaders(len(body))
-	headers.Override("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	w.WriteBody(body)
-	return
-}
-
-func handler500(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeInternalServerError)
-	body := []byte(`<html>
+	})
+	server.DefaultErrorPages.Handle(response.StatusCodeInternalServerError, "text/html", func(server.Problem) []byte {
+		return []byte(`<html>
   <head>
     <title>500 Internal Server Error</title>
   </head>
@@ -85,11 +206,7 @@ func handler500(w *response.Writer, _ *request.Request) {
     <p>Okay, you know what? This one is on me.</p>
   </body>
 </html>`)
-	headers := response.GetDefaultHeaders(len(body))
-	headers.Override("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	w.WriteBody(body)
-	return
+	})
 }
 
 func handler200(w *response.Writer, _ *request.Request) {
@@ -111,18 +228,43 @@ func handler200(w *response.Writer, _ *request.Request) {
 }
 
 func proxyHandler(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+	target := strings.TrimPrefix(req.Target.RawPath, "/httpbin/")
 	url := "https://httpbin.org/" + target
-	//proxying to url
-	resp, err := http.Get(url)
+	if req.Target.RawQuery != "" {
+		url += "?" + req.Target.RawQuery
+	}
+	//proxying to url, given up on if the client goes away
+	ctx, cancel := context.WithTimeout(req.Context(), proxyTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "GET", tracing.SpanKindClient)
+	defer span.Finish()
+	span.SetAttribute("url.full", url)
+	preq, err := client.NewRequest("GET", url, nil)
 	if err != nil {
+		span.SetError(err.Error())
+		handler500(w, req)
+		return
+	}
+	tracing.Inject(ctx, preq.Headers)
+	preq.Headers.Override(requestid.Header, req.ID)
+	resp, err := proxyClient.Do(ctx, preq)
+	if err != nil {
+		log.Printf("request %s: error proxying to %s: %v", req.ID, url, err)
+		span.SetError(err.Error())
 		handler500(w, req)
 		return
 	}
 	defer resp.Body.Close()
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetError("")
+	}
 
-	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
 	h := response.GetDefaultHeaders(0)
+	if ct, ok := resp.Headers.Get("Content-Type"); ok {
+		h.Override("Content-Type", ct)
+	}
 	h.Override("Transfer-Encoding", "chunked")
 	h.Override("Trailer", "X-Content-SHA256, X-Content-Length")
 	h.Remove("Content-Length")
@@ -133,11 +275,10 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 	buf := make([]byte, maxChunkSize)
 	for {
 		n, err := resp.Body.Read(buf)
-		fmt.Println("Read", n, "bytes")
 		if n > 0 {
 			_, err = w.WriteChunkedBody(buf[:n])
 			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
+				log.Printf("request %s: error writing chunked body: %v", req.ID, err)
 				break
 			}
 			chunkBody = append(chunkBody, buf[:n]...)
@@ -146,13 +287,13 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 			break
 		}
 		if err != nil {
-			fmt.Println("Error reading response body:", err)
+			log.Printf("request %s: error reading proxied body: %v", req.ID, err)
 			break
 		}
 	}
 	_, err = w.WriteChunkedBodyDone()
 	if err != nil {
-		fmt.Println("Error writing chunked body done:", err)
+		log.Printf("request %s: error writing chunked body done: %v", req.ID, err)
 	}
 
 	trailers := headers.NewHeaders()
@@ -162,9 +303,8 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 	trailers.Override("X-Content-Length", fmt.Sprintf("%d", len(chunkBody)))
 	err = w.WriteTrailers(trailers)
 	if err != nil {
-		fmt.Println("Error writing trailers:", err)
+		log.Printf("request %s: error writing trailers: %v", req.ID, err)
 	}
-	fmt.Println("Wrote trailers")
 }
 
 func videoHandler(w *response.Writer, _ *request.Request) {
@@ -180,3 +320,48 @@ func videoHandler(w *response.Writer, _ *request.Request) {
 	w.WriteHeaders(h)
 	w.WriteBody(videoBytes)
 }
+
+func uploadHandler(w *response.Writer, req *request.Request) {
+	//a declared length over the limit is refused before the client sends the body
+	err := req.ReadBody()
+	if errors.Is(err, request.ErrBodyTooLarge) {
+		server.Error(w, req, response.StatusCodeContentTooLarge, fmt.Sprintf("Uploads are limited to %d bytes.", maxUploadSize))
+		return
+	}
+	if err != nil {
+		handler400(w, req)
+		return
+	}
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	body := []byte(fmt.Sprintf("received %d bytes\n", len(req.Body)))
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func eventsHandler(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, sse.DefaultHeartbeat)
+	if err != nil {
+		log.Printf("request %s: error starting event stream: %v", req.ID, err)
+		return
+	}
+	defer stream.Close()
+
+	id := 0
+	if last, err := strconv.Atoi(stream.LastEventID()); err == nil {
+		id = last
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case t := <-ticker.C:
+			id++
+			err := stream.Send(sse.Event{ID: strconv.Itoa(id), Event: "tick", Data: t.Format(time.RFC3339)})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -39,7 +39,7 @@ func main() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
 		fmt.Printf("Body:\n")
-		fmt.Printf(string(req.Body))
+		fmt.Print(string(req.Body))
 	}
 }
 
@@ -0,0 +1,169 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/JA50N14/httpfromtcp/internal/server"
+)
+
+// Format is how each request is written to the access log.
+type Format int
+
+const (
+	// Common is Apache's Common Log Format:
+	// host ident authuser [time] "request" status bytes
+	Common Format = iota
+	// Combined is Common followed by the quoted Referer and User-Agent.
+	Combined
+	// JSON logs every field, duration and request ID included, as a slog
+	// JSON record.
+	JSON
+)
+
+// ParseFormat turns "common", "combined" or "json" into a Format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "common":
+		return Common, nil
+	case "combined":
+		return Combined, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("unknown access log format: %q", name)
+}
+
+// Entry is what gets logged about a request once its handler returns.
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	Target     string
+	Version    string
+	Status     response.StatusCode
+	Bytes      int
+	Duration   time.Duration
+	UserAgent  string
+	Referer    string
+	RequestID  string
+}
+
+// Logger logs every response to out in format when passed to
+// server.WithAccessLog, including the errors the server answers without
+// running the handler. Writes to out are serialised, so it can be shared by
+// all connections.
+func Logger(out io.Writer, format Format) server.AccessLogFunc {
+	var write func(e Entry)
+	if format == JSON {
+		logger := slog.New(slog.NewJSONHandler(out, nil))
+		write = func(e Entry) { logJSON(logger, e) }
+	} else {
+		var mu sync.Mutex
+		write = func(e Entry) {
+			line := e.common()
+			if format == Combined {
+				line += " " + quote(e.Referer) + " " + quote(e.UserAgent)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			io.WriteString(out, line+"\n")
+		}
+	}
+	return func(w *response.Writer, req *request.Request, start time.Time) {
+		write(newEntry(w, req, start))
+	}
+}
+
+// Middleware logs the requests that reach the handler to out in format. It
+// misses the 400s, 413s and 501s the server sends on its own; use Logger with
+// server.WithAccessLog to log those too.
+func Middleware(out io.Writer, format Format) server.Middleware {
+	logResponse := Logger(out, format)
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			//deferred so that requests whose handler panics are logged too
+			defer logResponse(w, req, start)
+			next(w, req)
+		}
+	}
+}
+
+func newEntry(w *response.Writer, req *request.Request, start time.Time) Entry {
+	userAgent, _ := req.Headers.Get("User-Agent")
+	referer, _ := req.Headers.Get("Referer")
+	return Entry{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.RequestLine.Method,
+		Target:     req.RequestLine.RequestTarget,
+		Version:    req.RequestLine.HttpVersion,
+		Status:     w.StatusCode(),
+		Bytes:      w.BytesWritten(),
+		Duration:   time.Since(start),
+		UserAgent:  userAgent,
+		Referer:    referer,
+		RequestID:  req.ID,
+	}
+}
+
+// common formats e in the Common Log Format, where "-" stands for anything
+// unknown. Nothing here checks who the client is, so ident and authuser are
+// always "-".
+func (e Entry) common() string {
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = "-"
+	}
+	status := "-"
+	if e.Status != 0 {
+		status = strconv.Itoa(int(e.Status))
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.Itoa(e.Bytes)
+	}
+	//requests the server couldn't parse have no request line
+	requestLine := ""
+	if e.Method != "" {
+		requestLine = fmt.Sprintf("%s %s HTTP/%s", e.Method, e.Target, e.Version)
+	}
+	return fmt.Sprintf("%s - - [%s] %s %s %s", host, e.Time.Format("02/Jan/2006:15:04:05 -0700"), quote(requestLine), status, bytes)
+}
+
+// quote wraps s in double quotes, escaping quotes and control characters so a
+// client can't forge log lines. Empty values are logged as "-".
+func quote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+func logJSON(logger *slog.Logger, e Entry) {
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+		slog.String("remote_addr", e.RemoteAddr),
+		slog.String("method", e.Method),
+		slog.String("target", e.Target),
+		slog.String("version", e.Version),
+		slog.Int("status", int(e.Status)),
+		slog.Int("bytes", e.Bytes),
+		slog.Float64("duration_ms", float64(e.Duration.Microseconds())/1000),
+		slog.String("user_agent", e.UserAgent),
+		slog.String("referer", e.Referer),
+		slog.String("request_id", e.RequestID),
+	)
+}
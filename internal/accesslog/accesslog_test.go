@@ -0,0 +1,111 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, format Format, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:51000"
+	req.ID = "abc123"
+	var log bytes.Buffer
+	h := Middleware(&log, format)(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeNotFound)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		w.WriteBody([]byte("nope\n"))
+	})
+	h(response.NewWriter(&bytes.Buffer{}), req)
+	return log.String()
+}
+
+const loggedRequest = "GET /a?b=c HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl/8.0 \"x\"\r\nReferer: http://example.com/\r\n\r\n"
+
+func TestFormats(t *testing.T) {
+	//Test: Common Log Format
+	line := serve(t, Common, loggedRequest)
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /a\?b=c HTTP/1\.1" 404 5\n$`), line)
+
+	//Test: Combined adds quoted, escaped referer and user agent
+	line = serve(t, Combined, loggedRequest)
+	assert.True(t, strings.HasSuffix(line, `404 5 "http://example.com/" "curl/8.0 \"x\""`+"\n"), line)
+	line = serve(t, Combined, "GET / HTTP/1.0\r\n\r\n")
+	assert.True(t, strings.HasSuffix(line, `"GET / HTTP/1.0" 404 5 "-" "-"`+"\n"), line)
+
+	//Test: JSON carries every field
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(serve(t, JSON, loggedRequest)), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "192.0.2.1:51000", record["remote_addr"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/a?b=c", record["target"])
+	assert.Equal(t, "1.1", record["version"])
+	assert.Equal(t, 404.0, record["status"])
+	assert.Equal(t, 5.0, record["bytes"])
+	assert.Contains(t, record, "duration_ms")
+	assert.Equal(t, `curl/8.0 "x"`, record["user_agent"])
+	assert.Equal(t, "http://example.com/", record["referer"])
+	assert.Equal(t, "abc123", record["request_id"])
+
+	_, err := ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestLogger(t *testing.T) {
+	//Test: A request the server couldn't parse is logged without a request line
+	var log bytes.Buffer
+	w := response.NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(response.StatusCodeBadRequest)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+	req := &request.Request{Headers: headers.NewHeaders(), RemoteAddr: "192.0.2.1:51000"}
+	Logger(&log, Common)(w, req, time.Now())
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - \[[^]]+\] "-" 400 -\n$`), log.String())
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	//Test: The newest lines are in path, older ones shifted up, the oldest dropped
+	for name, want := range map[string]string{
+		path:        "six\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "three\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	//Test: Reopening appends and counts what is already there
+	f, err = OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	f.Write([]byte("seventh\n"))
+	require.NoError(t, f.Close())
+	data, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "six\n", string(data))
+}
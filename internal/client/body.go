@@ -0,0 +1,57 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// body hands the connection back to the pool once the response has been read
+// to the end, or closes it if the body is abandoned early.
+type body struct {
+	reader   io.Reader
+	pc       *persistConn
+	reusable bool
+	done     bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	n, err := b.reader.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.done = true
+		b.pc.release(b.reusable)
+	} else if err != nil {
+		b.done = true
+		b.pc.release(false)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	b.pc.release(false)
+	return nil
+}
+
+// newBody streams resp's body off the connection with the response parser.
+func newBody(resp *Response, method string, pc *persistConn) (io.ReadCloser, error) {
+	if te, ok := resp.Headers.Get("Transfer-Encoding"); ok && method != "HEAD" {
+		codings := strings.Split(strings.ToLower(te), ",")
+		if len(codings) > 1 && strings.TrimSpace(codings[len(codings)-1]) == "chunked" {
+			return nil, fmt.Errorf("unsupported transfer-encoding: %s", te)
+		}
+	}
+	r, err := resp.head.NewBodyReader(pc.reader, method)
+	if err != nil {
+		return nil, err
+	}
+	resp.Trailers = resp.head.Trailers
+	return &body{reader: r, pc: pc, reusable: resp.keepAlive() && !r.CloseDelimited()}, nil
+}
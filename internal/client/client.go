@@ -0,0 +1,290 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+)
+
+const readBufferSize = 8 * 1024
+
+// ErrUseLastResponse can be returned by CheckRedirect to stop following
+// redirects and hand the redirect response itself to the caller.
+var ErrUseLastResponse = errors.New("use last response")
+
+// Client is an HTTP/1.1 client. Timeouts and cancellation come from the
+// context passed to Do, which also covers reading the response body.
+type Client struct {
+	TLSConfig *tls.Config
+	//Proxy routes http requests through an HTTP proxy and https requests through a CONNECT tunnel
+	Proxy *url.URL
+	//CheckRedirect decides whether to follow a redirect; the default follows up to 10
+	CheckRedirect func(req *Request, via []*Request) error
+
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    []byte
+}
+
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in url: %q", rawURL)
+	}
+	return &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends req and follows redirects. The caller must close the response Body.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	via := []*Request{}
+	for {
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		location, _ := resp.Headers.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			return resp, nil
+		}
+
+		next, err := redirectRequest(req, resp.StatusCode, location)
+		if err == nil {
+			via = append(via, req)
+			err = c.checkRedirect(next, via)
+		}
+		if errors.Is(err, ErrUseLastResponse) {
+			return resp, nil
+		}
+		//drain a little so small redirect bodies don't cost the connection
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+func (c *Client) checkRedirect(req *Request, via []*Request) error {
+	if c.CheckRedirect != nil {
+		return c.CheckRedirect(req, via)
+	}
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	return nil
+}
+
+func isRedirect(code int) bool {
+	return code == 301 || code == 302 || code == 303 || code == 307 || code == 308
+}
+
+func redirectRequest(prev *Request, statusCode int, location string) (*Request, error) {
+	u, err := prev.URL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect location %q: %v", location, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("redirect to unsupported scheme: %q", location)
+	}
+	next := &Request{
+		Method:  prev.Method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    prev.Body,
+	}
+	//303, and 301/302 after POST as browsers do, turn into a GET without body
+	if statusCode == 303 && prev.Method != "HEAD" || (statusCode == 301 || statusCode == 302) && prev.Method == "POST" {
+		next.Method = "GET"
+		next.Body = nil
+	}
+	for k, v := range prev.Headers {
+		switch k {
+		case "content-length", "content-type":
+			if next.Body == nil {
+				continue
+			}
+		case "authorization", "cookie":
+			//don't leak credentials to another host
+			if u.Host != prev.URL.Host {
+				continue
+			}
+		}
+		next.Headers[k] = v
+	}
+	return next, nil
+}
+
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
+	key := connKey(req.URL, c.Proxy)
+	for attempt := 0; ; attempt++ {
+		pc := c.getIdle(key)
+		reused := pc != nil
+		if !reused {
+			var err error
+			pc, err = c.dial(ctx, req.URL)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.roundTrip(ctx, pc, req)
+		if err == nil {
+			return resp, nil
+		}
+		pc.release(false)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		//the server may have closed an idle connection just as we reused it
+		if reused && attempt == 0 && idempotent(req.Method) {
+			continue
+		}
+		return nil, err
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *Request) (*Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetDeadline(deadline)
+	}
+	pc.stopCtx = context.AfterFunc(ctx, func() {
+		//unblock any read or write in progress
+		pc.conn.SetDeadline(time.Now())
+	})
+
+	w := bufio.NewWriter(pc.conn)
+	err := writeRequest(w, req, pc.viaProxy, c.Proxy)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := readResponseHead(pc.reader)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	resp.Body, err = newBody(resp, req.Method, pc)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func writeRequest(w io.Writer, req *Request, viaProxy bool, proxy *url.URL) error {
+	target := req.URL.RequestURI()
+	if viaProxy {
+		//plain HTTP proxies want the absolute-form target
+		u := *req.URL
+		u.Fragment = ""
+		target = u.String()
+	}
+	_, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, target, req.URL.Host)
+	if err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+	for k, v := range req.Headers {
+		h[k] = v
+	}
+	h.Remove("Host")
+	h.Remove("Transfer-Encoding")
+	if _, ok := h.Get("User-Agent"); !ok {
+		h.Set("User-Agent", "httpfromtcp")
+	}
+	if len(req.Body) > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		h.Override("Content-Length", strconv.Itoa(len(req.Body)))
+	} else {
+		h.Remove("Content-Length")
+	}
+	if auth := proxyAuthorization(proxy); viaProxy && auth != "" {
+		h.Override("Proxy-Authorization", auth)
+	}
+	for k, v := range h {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid value for header %s", k)
+		}
+		_, err = fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "\r\n")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(req.Body)
+	return err
+}
+
+func (c *Client) maxIdlePerHost() int {
+	if c.MaxIdleConnsPerHost > 0 {
+		return c.MaxIdleConnsPerHost
+	}
+	return 2
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleConnTimeout > 0 {
+		return c.IdleConnTimeout
+	}
+	return 90 * time.Second
+}
+
+func (c *Client) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return 30 * time.Second
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer answers each request line it reads with the response returned by
+// reply and counts accepted connections.
+func fakeServer(t *testing.T, reply func(requestLine string) string) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	conns := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					for {
+						h, err := r.ReadString('\n')
+						if err != nil || h == "\r\n" {
+							break
+						}
+					}
+					resp := reply(strings.TrimSpace(line))
+					conn.Write([]byte(resp))
+					if strings.Contains(resp, "Connection: close") {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String(), conns
+}
+
+func TestClientBodies(t *testing.T) {
+	base, conns := fakeServer(t, func(line string) string {
+		switch {
+		case strings.Contains(line, "/chunked"):
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\n"
+		case strings.Contains(line, "/close"):
+			return "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nuntil the end"
+		case strings.Contains(line, "/negative"):
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\nx\r\n0\r\n\r\n"
+		case strings.Contains(line, "/continue"):
+			return "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"
+		default:
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfixed"
+		}
+	})
+	c := &Client{}
+	ctx := context.Background()
+
+	//Test: Content-Length body and connection reuse
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(ctx, base+"/fixed")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "fixed", string(body))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+
+	//Test: Chunked body with extensions and trailers
+	resp, err := c.Get(ctx, base+"/chunked")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	//Test: Interim responses are skipped
+	resp, err = c.Get(ctx, base+"/continue")
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+	resp.Body.Close()
+
+	//Test: A negative chunk size from the server is an error
+	resp, err = c.Get(ctx, base+"/negative")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.Error(t, err)
+
+	//Test: Close-delimited body
+	resp, err = c.Get(ctx, base+"/close")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+}
+
+func TestClientRedirects(t *testing.T) {
+	base, _ := fakeServer(t, func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "POST /see-other"):
+			return "HTTP/1.1 303 See Other\r\nLocation: /done\r\nContent-Length: 0\r\n\r\n"
+		case strings.Contains(line, "/loop"):
+			return "HTTP/1.1 302 Found\r\nLocation: /loop\r\nContent-Length: 0\r\n\r\n"
+		default:
+			return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+		}
+	})
+	c := &Client{}
+	ctx := context.Background()
+
+	//Test: 303 after POST becomes a GET
+	req, err := NewRequest("POST", base+"/see-other", []byte("data"))
+	require.NoError(t, err)
+	resp, err := c.Do(ctx, req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "GET", resp.Request.Method)
+	assert.Equal(t, "/done", resp.Request.URL.Path)
+
+	//Test: Redirect loops stop
+	_, err = c.Get(ctx, base+"/loop")
+	require.Error(t, err)
+
+	//Test: Policy can hand back the redirect itself
+	c.CheckRedirect = func(*Request, []*Request) error { return ErrUseLastResponse }
+	resp, err = c.Get(ctx, base+"/loop")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 302, resp.StatusCode)
+}
+
+func TestClientConnectCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			//a proxy that never answers CONNECT
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	//Test: Cancelling the request stops the CONNECT handshake
+	proxy, err := url.Parse("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = (&Client{Proxy: proxy}).Get(ctx, "https://example.com/")
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestClientTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			//never answer
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = (&Client{}).Get(ctx, "http://"+listener.Addr().String()+"/")
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+type persistConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	key    string
+	client *Client
+	//stopCtx detaches the request's context from the connection
+	stopCtx  func() bool
+	idleAt   time.Time
+	viaProxy bool
+}
+
+// release is called once the response body is done with the connection.
+func (pc *persistConn) release(reusable bool) {
+	if pc.stopCtx != nil && !pc.stopCtx() {
+		//the context fired and poisoned the connection deadline
+		reusable = false
+	}
+	pc.stopCtx = nil
+	if !reusable {
+		pc.conn.Close()
+		return
+	}
+	pc.conn.SetDeadline(time.Time{})
+	pc.client.putIdle(pc)
+}
+
+func connKey(u *url.URL, proxy *url.URL) string {
+	key := u.Scheme + "://" + hostPort(u)
+	if proxy != nil {
+		key = proxy.String() + "|" + key
+	}
+	return key
+}
+
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (c *Client) getIdle(key string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		c.idle[key] = conns
+		if time.Since(pc.idleAt) > c.idleTimeout() {
+			pc.conn.Close()
+			continue
+		}
+		return pc
+	}
+	return nil
+}
+
+func (c *Client) putIdle(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = map[string][]*persistConn{}
+	}
+	if len(c.idle[pc.key]) >= c.maxIdlePerHost() {
+		pc.conn.Close()
+		return
+	}
+	pc.idleAt = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+// CloseIdleConnections closes every pooled connection that isn't in use.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+func (c *Client) dial(ctx context.Context, u *url.URL) (*persistConn, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout()}
+	pc := &persistConn{
+		key:    connKey(u, c.Proxy),
+		client: c,
+	}
+
+	addr := hostPort(u)
+	if c.Proxy != nil {
+		addr = hostPort(c.Proxy)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.Proxy != nil && c.Proxy.Scheme == "https" {
+		conn, err = c.handshake(ctx, conn, c.Proxy.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Proxy != nil && u.Scheme == "https" {
+		//tunnel through the proxy and speak TLS to the origin inside it
+		err = c.connect(ctx, conn, u)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else if c.Proxy != nil {
+		pc.viaProxy = true
+	}
+
+	if u.Scheme == "https" {
+		conn, err = c.handshake(ctx, conn, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	}
+	pc.conn = conn
+	pc.reader = bufio.NewReaderSize(conn, readBufferSize)
+	return pc, nil
+}
+
+func (c *Client) handshake(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error) {
+	cfg := &tls.Config{}
+	if c.TLSConfig != nil {
+		cfg = c.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	//this client only speaks HTTP/1.1
+	cfg.NextProtos = []string{"http/1.1"}
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (c *Client) connect(ctx context.Context, conn net.Conn, u *url.URL) (err error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		//unblock the handshake if the request is cancelled
+		conn.SetDeadline(time.Now())
+	})
+	defer func() {
+		stop()
+		conn.SetDeadline(time.Time{})
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+	addr := hostPort(u)
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if auth := proxyAuthorization(c.Proxy); auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	_, err = conn.Write([]byte(req + "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	//read byte by byte so nothing the origin sends after the tunnel opens is lost
+	resp, err := readResponseHead(bufio.NewReader(&oneByteReader{conn}))
+	if err != nil {
+		return fmt.Errorf("proxy CONNECT: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("proxy CONNECT returned %d %s", resp.StatusCode, resp.ReasonPhrase)
+	}
+	return nil
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func proxyAuthorization(proxy *url.URL) string {
+	if proxy == nil || proxy.User == nil {
+		return ""
+	}
+	pass, _ := proxy.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+pass))
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+type Response struct {
+	StatusCode   int
+	ReasonPhrase string
+	HttpVersion  string
+	Headers      headers.Headers
+	//Trailers is filled in once a chunked Body has been read to EOF
+	Trailers headers.Headers
+	Body     io.ReadCloser
+	//Request is the request that produced this response, after any redirects
+	Request *Request
+
+	//head is the parsed head, whose parser goes on to read the body
+	head *response.Response
+}
+
+// readResponseHead reads the status line and headers, skipping any 1xx interim responses.
+func readResponseHead(r *bufio.Reader) (*Response, error) {
+	head, err := response.ResponseHeadFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		StatusCode:   int(head.StatusLine.StatusCode),
+		ReasonPhrase: head.StatusLine.ReasonPhrase,
+		HttpVersion:  head.StatusLine.HttpVersion,
+		Headers:      head.Headers,
+		Trailers:     head.Trailers,
+		head:         head,
+	}, nil
+}
+
+// keepAlive reports whether the server left the connection open for another request.
+func (resp *Response) keepAlive() bool {
+	conn, _ := resp.Headers.Get("Connection")
+	for _, token := range strings.Split(conn, ",") {
+		switch strings.ToLower(strings.TrimSpace(token)) {
+		case "close":
+			return false
+		case "keep-alive":
+			return true
+		}
+	}
+	return resp.HttpVersion == "1.1"
+}
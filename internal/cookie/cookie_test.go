@@ -0,0 +1,60 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	//Test: Multiple cookies with quoted value and junk pairs
+	cookies := Parse(`session=abc123; theme="dark"; bad name=x; novalue; empty=; bad=a,b`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "empty", cookies[2].Name)
+	assert.Equal(t, "", cookies[2].Value)
+
+	//Test: Empty header
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	//Test: All attributes
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Domain:      ".example.com",
+		Path:        "/docs",
+		Expires:     time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Domain=example.com; Path=/docs; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	//Test: Deleting a cookie
+	c = &Cookie{Name: "id", MaxAge: -1}
+	assert.Equal(t, "id=; Max-Age=0", c.String())
+}
+
+func TestValid(t *testing.T) {
+	for _, c := range []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "bad;name", Value: "x"},
+		{Name: "id", Value: "has space"},
+		{Name: "id", Value: `quote"d`},
+		{Name: "id", Value: "x", Domain: "bad_domain.com"},
+		{Name: "id", Value: "x", Path: "/a;b"},
+		{Name: "id", Value: "x", SameSite: SameSiteNone},
+		{Name: "id", Value: "x", Partitioned: true},
+	} {
+		require.Error(t, c.Valid(), c.String())
+	}
+}
@@ -0,0 +1,260 @@
+package headers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MediaRange is one element of an Accept header, such as text/html;level=1;q=0.7.
+// Type and Subtype are lowercased and may be "*".
+type MediaRange struct {
+	Type    string
+	Subtype string
+	//Params holds the media type parameters, which come before q
+	Params map[string]string
+	Q      float64
+}
+
+// Weighted is one element of a header like Accept-Language or Accept-Charset.
+type Weighted struct {
+	Value string
+	Q     float64
+}
+
+// ParseAccept parses an Accept value in the order it was sent. Malformed
+// elements are skipped rather than failing the whole header.
+func ParseAccept(value string) []MediaRange {
+	var ranges []MediaRange
+	for _, elem := range splitList(value, ',') {
+		if elem == "" {
+			continue
+		}
+		parts := splitList(elem, ';')
+		typ, subtype, ok := parseMediaType(parts[0])
+		if !ok || typ == "*" && subtype != "*" {
+			continue
+		}
+		m := MediaRange{Type: typ, Subtype: subtype, Q: 1}
+		for _, param := range parts[1:] {
+			name, val, _ := strings.Cut(param, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			val = unquote(strings.TrimSpace(val))
+			if name == "q" {
+				m.Q, ok = parseQ(val)
+				//anything after q is an extension, not a media type parameter
+				break
+			}
+			if m.Params == nil {
+				m.Params = make(map[string]string)
+			}
+			m.Params[name] = val
+		}
+		if ok {
+			ranges = append(ranges, m)
+		}
+	}
+	return ranges
+}
+
+// ParseWeighted parses a list of values with optional q-values, as sent in
+// Accept-Language, Accept-Charset and Accept-Encoding.
+func ParseWeighted(value string) []Weighted {
+	var list []Weighted
+	for _, elem := range splitList(value, ',') {
+		if elem == "" {
+			continue
+		}
+		parts := splitList(elem, ';')
+		w := Weighted{Value: parts[0], Q: 1}
+		ok := IsToken(w.Value)
+		for _, param := range parts[1:] {
+			name, val, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				w.Q, ok = parseQ(strings.TrimSpace(val))
+				break
+			}
+		}
+		if ok {
+			list = append(list, w)
+		}
+	}
+	return list
+}
+
+// NegotiateMediaType picks the offer the Accept value likes best. Each offer
+// gets the q-value of the most specific range that matches it, so
+// "text/*;q=0.5, text/csv" still prefers text/csv over text/html; ties go to
+// the earlier offer. ok is false when no offer is acceptable. An absent or
+// unparseable header accepts anything.
+func NegotiateMediaType(accept string, offers []string) (best string, ok bool) {
+	ranges := ParseAccept(accept)
+	if len(ranges) == 0 {
+		return firstOffer(offers)
+	}
+	return negotiate(offers, func(offer string) float64 {
+		parts := splitList(offer, ';')
+		typ, subtype, ok := parseMediaType(parts[0])
+		if !ok {
+			return 0
+		}
+		q, specificity := 0.0, -1
+		for _, m := range ranges {
+			s := m.specificity(typ, subtype, parts[1:])
+			if s > specificity {
+				q, specificity = m.Q, s
+			}
+		}
+		return q
+	})
+}
+
+// NegotiateLanguage picks the offered language tag the Accept-Language value
+// likes best. A range matches a tag equal to it or starting with it followed
+// by "-", so "en" covers "en-GB", and the longest matching range decides.
+func NegotiateLanguage(acceptLanguage string, offers []string) (best string, ok bool) {
+	ranges := ParseWeighted(acceptLanguage)
+	if len(ranges) == 0 {
+		return firstOffer(offers)
+	}
+	return negotiate(offers, func(offer string) float64 {
+		q, longest := 0.0, -1
+		for _, r := range ranges {
+			n := len(r.Value)
+			match := r.Value == "*" || strings.EqualFold(offer, r.Value) ||
+				len(offer) > n && offer[n] == '-' && strings.EqualFold(offer[:n], r.Value)
+			if r.Value == "*" {
+				n = 0
+			}
+			if match && n > longest {
+				q, longest = r.Q, n
+			}
+		}
+		return q
+	})
+}
+
+// NegotiateCharset picks the offered charset the Accept-Charset value likes
+// best. Names match case-insensitively and an exact name outranks "*".
+func NegotiateCharset(acceptCharset string, offers []string) (best string, ok bool) {
+	ranges := ParseWeighted(acceptCharset)
+	if len(ranges) == 0 {
+		return firstOffer(offers)
+	}
+	return negotiate(offers, func(offer string) float64 {
+		q, found := 0.0, false
+		for _, r := range ranges {
+			if strings.EqualFold(offer, r.Value) {
+				return r.Q
+			}
+			if r.Value == "*" && !found {
+				q, found = r.Q, true
+			}
+		}
+		return q
+	})
+}
+
+// specificity reports how closely m matches the media type, from 0 for */* to
+// 3 for a full type with parameters, or -1 if it doesn't match at all.
+func (m MediaRange) specificity(typ, subtype string, params []string) int {
+	switch {
+	case m.Type == "*":
+		return 0
+	case m.Type != typ:
+		return -1
+	case m.Subtype == "*":
+		return 1
+	case m.Subtype != subtype:
+		return -1
+	case len(m.Params) == 0:
+		return 2
+	}
+	matched := 0
+	for _, param := range params {
+		name, val, _ := strings.Cut(param, "=")
+		want, ok := m.Params[strings.ToLower(strings.TrimSpace(name))]
+		if ok && want == unquote(strings.TrimSpace(val)) {
+			matched++
+		}
+	}
+	if matched != len(m.Params) {
+		return -1
+	}
+	return 3
+}
+
+func negotiate(offers []string, q func(offer string) float64) (string, bool) {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if oq := q(offer); oq > bestQ {
+			best, bestQ = offer, oq
+		}
+	}
+	return best, bestQ > 0
+}
+
+func firstOffer(offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+	return offers[0], true
+}
+
+func parseMediaType(s string) (typ, subtype string, ok bool) {
+	typ, subtype, ok = strings.Cut(strings.ToLower(strings.TrimSpace(s)), "/")
+	return typ, subtype, ok && IsToken(typ) && IsToken(subtype)
+}
+
+// parseQ parses a qvalue: "0" or "1" with up to three decimals, at most 1.
+func parseQ(s string) (float64, bool) {
+	if len(s) == 0 || len(s) > 5 || s[0] != '0' && s[0] != '1' {
+		return 0, false
+	}
+	if len(s) > 1 && s[1] != '.' {
+		return 0, false
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// splitList splits s at sep, except inside quoted strings, and trims each element.
+func splitList(s string, sep byte) []string {
+	var list []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			list = append(list, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(list, strings.TrimSpace(s[start:]))
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
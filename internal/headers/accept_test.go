@@ -0,0 +1,83 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccept(t *testing.T) {
+	//Test: Ranges keep their order, parameters and q-values
+	ranges := ParseAccept(`text/html, Text/CSV;header=present;q=0.5, */*;q=0.1;ext=1, application/json;profile="a,b"`)
+	assert.Equal(t, []MediaRange{
+		{Type: "text", Subtype: "html", Q: 1},
+		{Type: "text", Subtype: "csv", Params: map[string]string{"header": "present"}, Q: 0.5},
+		{Type: "*", Subtype: "*", Q: 0.1},
+		{Type: "application", Subtype: "json", Params: map[string]string{"profile": "a,b"}, Q: 1},
+	}, ranges)
+
+	//Test: Malformed elements are skipped
+	ranges = ParseAccept("text, */html, text/html;q=2, text/plain;q=0.1234, , image/png;q=0")
+	assert.Equal(t, []MediaRange{{Type: "image", Subtype: "png", Q: 0}}, ranges)
+}
+
+func TestParseWeighted(t *testing.T) {
+	assert.Equal(t, []Weighted{{"da", 1}, {"en-GB", 0.8}, {"*", 0.1}}, ParseWeighted("da, en-GB;q=0.8, *;q=0.1, bad value, en;q=x"))
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	offers := []string{"application/json", "text/csv", "text/html"}
+	for accept, want := range map[string]string{
+		"":                                  "application/json",
+		"*/*":                               "application/json",
+		"text/html":                         "text/html",
+		"text/*":                            "text/csv",
+		"text/*;q=0.5, text/html":           "text/html",
+		"text/*, text/csv;q=0.2":            "text/html",
+		"*/*;q=0.1, text/csv":               "text/csv",
+		"application/json;q=0, */*":         "text/csv",
+		"text/html;level=1, text/csv;q=0.5": "text/csv",
+		"text/html, application/json;q=0.9": "text/html",
+		"garbage":                           "application/json",
+		"text/csv;header=present;q=0.9, text/*;q=0.8": "text/csv",
+	} {
+		got, ok := NegotiateMediaType(accept, offers)
+		assert.True(t, ok, accept)
+		assert.Equal(t, want, got, accept)
+	}
+
+	//Test: Parameters on an offer must match the range's
+	got, ok := NegotiateMediaType("text/csv;header=present", []string{"text/csv", "text/csv;header=present"})
+	assert.True(t, ok)
+	assert.Equal(t, "text/csv;header=present", got)
+
+	for _, accept := range []string{"image/png", "text/html;q=0, application/*;q=0, text/csv;q=0"} {
+		_, ok := NegotiateMediaType(accept, offers)
+		assert.False(t, ok, accept)
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	offers := []string{"en-US", "en-GB", "da"}
+	for accept, want := range map[string]string{
+		"":                      "en-US",
+		"da, en;q=0.8":          "da",
+		"en-gb, en;q=0.8":       "en-GB",
+		"en;q=0.5, en-US;q=0.1": "en-GB",
+		"fr, *;q=0.1":           "en-US",
+	} {
+		got, ok := NegotiateLanguage(accept, offers)
+		assert.True(t, ok, accept)
+		assert.Equal(t, want, got, accept)
+	}
+	_, ok := NegotiateLanguage("fr, e", offers)
+	assert.False(t, ok)
+}
+
+func TestNegotiateCharset(t *testing.T) {
+	got, ok := NegotiateCharset("ISO-8859-1;q=0.5, *;q=0.9", []string{"iso-8859-1", "utf-8"})
+	assert.True(t, ok)
+	assert.Equal(t, "utf-8", got)
+	_, ok = NegotiateCharset("utf-8;q=0, *;q=0", []string{"utf-8"})
+	assert.False(t, ok)
+}
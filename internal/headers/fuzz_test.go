@@ -0,0 +1,57 @@
+package headers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// FuzzHeadersParse feeds a header section to Parse one line at a time and
+// checks that whatever it accepts is a lowercase token name with values free
+// of control characters, so nothing it stores can split a line when written
+// back out.
+func FuzzHeadersParse(f *testing.F) {
+	for _, seed := range []string{
+		"Host: localhost:42069\r\n\r\n",
+		"HoSt: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		"Host:   localhost: 42069      \r\n",
+		"       Host : localhost:42069       \r\n\r\n",
+		"X-Tab:\ta\tb\t\r\n\r\n",
+		"Set-Cookie: a=1\r\nSet-Cookie: b=2\r\nCookie: c=3\r\nCookie: d=4\r\n\r\n",
+		"Content-Length: 5\r\nContent-Length: 7\r\n\r\n",
+		"X-Folded: a\r\n b\r\n\r\n",
+		"X-Bare: a\nb\r\n\r\n",
+		"X-Obs-Text: \xe2\x80\x94\r\n\r\n",
+		": empty\r\n\r\n",
+		"no-colon\r\n\r\n",
+		"\r\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		h := NewHeaders()
+		buf := []byte(data)
+		for {
+			n, done, err := h.Parse(buf)
+			if err != nil {
+				require.Equal(t, 0, n)
+				break
+			}
+			require.LessOrEqual(t, n, len(buf))
+			buf = buf[n:]
+			if done || n == 0 {
+				break
+			}
+		}
+		for key := range h {
+			assert.True(t, IsToken(key), "%q", key)
+			assert.Equal(t, strings.ToLower(key), key)
+			for _, value := range h.Values(key) {
+				assert.True(t, validValue([]byte(value)), "%q", value)
+			}
+		}
+	})
+}
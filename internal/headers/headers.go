@@ -2,13 +2,38 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"slices"
 )
 
 const crlf = "\r\n"
 
+// MaxFields and MaxSectionSize bound one header or trailer section. Repeated
+// fields are joined into one growing value, so without them a client could
+// keep the parser copying by sending short lines forever.
+const (
+	MaxFields      = 100
+	MaxSectionSize = 64 * 1024
+)
+
+// ErrSectionTooLarge is returned by CheckSection.
+var ErrSectionTooLarge = errors.New("header section too large")
+
+// CheckSection fails once a section has more than MaxFields lines or
+// MaxSectionSize bytes. Parsers call it with their running totals.
+func CheckSection(fields, size int) error {
+	if fields > MaxFields {
+		return fmt.Errorf("%w: more than %d fields", ErrSectionTooLarge, MaxFields)
+	}
+	if size > MaxSectionSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrSectionTooLarge, MaxSectionSize)
+	}
+	return nil
+}
+
 type Headers map[string]string
 
 func NewHeaders() Headers {
@@ -27,28 +52,50 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 2, true, nil
 	}
 
-	parts := bytes.SplitN(data[:idx], []byte(":"), 2)
+	line := data[:idx]
+	if line[0] == ' ' || line[0] == '\t' {
+		//obs-fold continuation lines, or whitespace before the first header, are not accepted
+		return 0, false, fmt.Errorf("invalid header line starting with whitespace: %q", line)
+	}
+	parts := bytes.SplitN(line, []byte(":"), 2)
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("invalid header line, missing colon: %q", line)
+	}
 	key := strings.ToLower(string(parts[0]))
 
 	if key != strings.TrimRight(key, " ") {
 		return 0, false, fmt.Errorf("invalid header name: %s", key)
 	}
 
-	value := bytes.TrimSpace(parts[1])
+	value := bytes.Trim(parts[1], " \t")
 	key = strings.TrimSpace(key)
 
-	if !validTokens([]byte(key)) {
+	if !IsToken(key) {
 		return 0, false, fmt.Errorf("invalid header token found: %s", key)
 	}
+	if !validValue(value) {
+		return 0, false, fmt.Errorf("invalid character in header value: %q", value)
+	}
 
 	h.Set(key, string(value))
 	return idx + 2, false, nil
 }
 
 
+// Set adds a value, joining repeated fields into one comma-separated list.
+// Set-Cookie can't be joined that way, so its values are kept on separate
+// lines (see Values), and Cookie crumbs are joined with "; ".
 func (h Headers) Set(key, value string) {
+	key = strings.ToLower(key)
 	if val, ok := h[key]; ok {
-		h[key] = val + ", " + value
+		switch key {
+		case "set-cookie":
+			h[key] = val + "\n" + value
+		case "cookie":
+			h[key] = val + "; " + value
+		default:
+			h[key] = val + ", " + value
+		}
 	} else {
 		h[key] = value
 	}
@@ -56,6 +103,7 @@ func (h Headers) Set(key, value string) {
 
 
 func (h Headers) Override(key, value string) {
+	key = strings.ToLower(key)
 	h[key] = value
 }
 
@@ -71,6 +119,83 @@ func validTokens(data []byte) bool {
 	return true
 }
 
+// validValue rejects control characters, which covers the bare CR, LF and NUL
+// that let a value smuggle in another line. HTAB is allowed.
+func validValue(value []byte) bool {
+	for _, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseContentLength parses a Content-Length value. Repeated fields arrive
+// joined into a list, which is only accepted when every value is the same.
+// Signs, spaces and anything but digits are errors.
+func ParseContentLength(value string) (int, error) {
+	n := -1
+	for _, v := range strings.Split(value, ",") {
+		v = strings.Trim(v, " \t")
+		if v == "" || len(v) > 18 {
+			return 0, fmt.Errorf("invalid content-length: %q", value)
+		}
+		for i := 0; i < len(v); i++ {
+			if v[i] < '0' || v[i] > '9' {
+				return 0, fmt.Errorf("invalid content-length: %q", value)
+			}
+		}
+		m, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid content-length: %q", value)
+		}
+		if n != -1 && m != n {
+			return 0, fmt.Errorf("conflicting content-length values: %q", value)
+		}
+		n = m
+	}
+	return n, nil
+}
+
+// ParseChunkSize reads chunk-size [ chunk-ext ], the line before each chunk
+// of a chunked body. The size is plain hex with no sign, prefix or padding,
+// at most 15 digits so it can't overflow, and extensions are ignored once
+// checked for control characters.
+func ParseChunkSize(line []byte) (int, error) {
+	size, ext, _ := bytes.Cut(line, []byte(";"))
+	size = bytes.TrimRight(size, " \t")
+	if len(size) == 0 || len(size) > 15 {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+	n := 0
+	for _, c := range size {
+		var d byte
+		switch {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'a' && c <= 'f':
+			d = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, fmt.Errorf("invalid chunk size: %q", line)
+		}
+		n = n<<4 | int(d)
+	}
+	for _, c := range ext {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return 0, fmt.Errorf("invalid chunk extension: %q", line)
+		}
+	}
+	return n, nil
+}
+
+// IsToken reports whether s is a non-empty token, the grammar shared by field
+// names and request methods.
+func IsToken(s string) bool {
+	return len(s) > 0 && validTokens([]byte(s))
+}
+
 //checks to ensure the data only contains valid tokens or characters that are allowed in a token
 func isTokenChar(c byte) bool {
 	if c >= 'A' && c <= 'Z' ||
@@ -82,11 +207,24 @@ func isTokenChar(c byte) bool {
 }
 
 func (h Headers) Get(key string) (string, bool) {
-	v, ok := h[key]
+	v, ok := h[strings.ToLower(key)]
 	return v, ok
 }
 
-func (h Headers) Remove(key string) {
-	delete(h, key)
+// Values returns each field line that was set for key. Only Set-Cookie keeps
+// more than one, since every other field is combined by Set.
+func (h Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	v, ok := h[key]
+	if !ok {
+		return nil
+	}
+	if key == "set-cookie" {
+		return strings.Split(v, "\n")
+	}
+	return []string{v}
+}
 
+func (h Headers) Remove(key string) {
+	delete(h, strings.ToLower(key))
 }
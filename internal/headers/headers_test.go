@@ -45,14 +45,22 @@ func TestHeadersParse(t *testing.T) {
 
 	//Test: Valid single header with extra whitespace
 	headers = NewHeaders()
-	data = []byte("  Host:   localhost: 42069      \r\n")
+	data = []byte("Host:   localhost: 42069      \r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
 	assert.Equal(t, "localhost: 42069", headers["host"])
-	assert.Equal(t, 34, n)
+	assert.Equal(t, 32, n)
 	assert.Equal(t, false, done)
 
+	//Test: Leading whitespace looks like obs-fold and is rejected
+	headers = NewHeaders()
+	data = []byte("  Host:   localhost: 42069      \r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
 	//Test: Valid 2 headers with existing headers
 	headers = NewHeaders()
 	headers["content-type"] = "application/json"
@@ -119,4 +127,84 @@ func TestHeadersParse(t *testing.T) {
 	require.Equal(t, 23, n)
 	require.Equal(t, 1, len(headers))
 	require.Equal(t, false, done)
-}	
+}
+
+func TestHeadersSetCookie(t *testing.T) {
+	//Test: Set-Cookie values stay separate
+	headers := NewHeaders()
+	headers.Set("Set-Cookie", "a=1; Path=/")
+	headers.Set("Set-Cookie", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"}, headers.Values("set-cookie"))
+
+	//Test: Cookie crumbs are joined with semicolons
+	headers = NewHeaders()
+	headers.Set("Cookie", "a=1")
+	headers.Set("Cookie", "b=2")
+	assert.Equal(t, "a=1; b=2", headers["cookie"])
+	assert.Equal(t, []string{"a=1; b=2"}, headers.Values("Cookie"))
+}
+
+func TestHeadersParseInvalid(t *testing.T) {
+	for _, line := range []string{
+		"X-Broken\r\n",
+		": value\r\n",
+		" X-Folded: value\r\n",
+		"\tX-Folded: value\r\n",
+		"X-Bare: a\nb\r\n",
+		"X-Bare: a\rb\r\n",
+		"X-Null: a\x00b\r\n",
+		"X-Del: a\x7fb\r\n",
+	} {
+		headers := NewHeaders()
+		n, done, err := headers.Parse([]byte(line))
+		require.Error(t, err, "%q", line)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+
+	//Test: Tabs and obs-text are allowed in values
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("X-Tab: a\tb \xe2\x80\x94\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\tb \xe2\x80\x94", headers["x-tab"])
+}
+
+func TestParseContentLength(t *testing.T) {
+	for value, want := range map[string]int{
+		"0":         0,
+		"42":        42,
+		"007":       7,
+		"5, 5":      5,
+		"5,5,5":     5,
+		"123456789": 123456789,
+	} {
+		n, err := ParseContentLength(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, n, value)
+	}
+
+	for _, value := range []string{"", "-1", "+1", "0x10", "1e3", "1 0", "5, 6", "5,", "abc", "9999999999999999999"} {
+		_, err := ParseContentLength(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestParseChunkSize(t *testing.T) {
+	for line, want := range map[string]int{
+		"0":               0,
+		"a":               10,
+		"1F":              31,
+		"5;name=value":    5,
+		"5 ;ext":          5,
+		"fffffffffffffff": 1<<60 - 1,
+	} {
+		n, err := ParseChunkSize([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, want, n, line)
+	}
+
+	for _, line := range []string{"", "-1", "+5", "-0", "0x5", " 5", "5\x00", "zz", "1000000000000000", "5;\x01"} {
+		_, err := ParseChunkSize([]byte(line))
+		require.Error(t, err, line)
+	}
+}
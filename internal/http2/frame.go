@@ -0,0 +1,153 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+const (
+	flagEndStream  uint8 = 0x1
+	flagAck        uint8 = 0x1
+	flagEndHeaders uint8 = 0x4
+	flagPadded     uint8 = 0x8
+	flagPriority   uint8 = 0x20
+)
+
+type errorCode uint32
+
+const (
+	errCodeNo                 errorCode = 0x0
+	errCodeProtocol           errorCode = 0x1
+	errCodeInternal           errorCode = 0x2
+	errCodeFlowControl        errorCode = 0x3
+	errCodeSettingsTimeout    errorCode = 0x4
+	errCodeStreamClosed       errorCode = 0x5
+	errCodeFrameSize          errorCode = 0x6
+	errCodeRefusedStream      errorCode = 0x7
+	errCodeCancel             errorCode = 0x8
+	errCodeCompression        errorCode = 0x9
+	errCodeConnect            errorCode = 0xa
+	errCodeEnhanceYourCalm    errorCode = 0xb
+	errCodeInadequateSecurity errorCode = 0xc
+	errCodeHTTP11Required     errorCode = 0xd
+)
+
+type settingID uint16
+
+const (
+	settingHeaderTableSize      settingID = 0x1
+	settingEnablePush           settingID = 0x2
+	settingMaxConcurrentStreams settingID = 0x3
+	settingInitialWindowSize    settingID = 0x4
+	settingMaxFrameSize         settingID = 0x5
+	settingMaxHeaderListSize    settingID = 0x6
+)
+
+const (
+	frameHeaderLen       = 9
+	defaultMaxFrameSize  = 16384
+	maxAllowedFrameSize  = 1<<24 - 1
+	defaultWindowSize    = 65535
+	maxWindowSize        = 1<<31 - 1
+	defaultHeaderTableSz = 4096
+)
+
+type frame struct {
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f *frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// connError is fatal for the whole connection and is answered with GOAWAY.
+type connError struct {
+	code   errorCode
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2 connection error %d: %s", e.code, e.reason)
+}
+
+// streamError only affects one stream and is answered with RST_STREAM.
+type streamError struct {
+	streamID uint32
+	code     errorCode
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2 stream %d error %d: %s", e.streamID, e.code, e.reason)
+}
+
+func readFrame(r io.Reader, maxSize uint32) (*frame, error) {
+	var hdr [frameHeaderLen]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	length := uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2])
+	if length > maxSize {
+		return nil, connError{errCodeFrameSize, fmt.Sprintf("frame of %d bytes exceeds max frame size %d", length, maxSize)}
+	}
+	f := &frame{
+		typ:      frameType(hdr[3]),
+		flags:    hdr[4],
+		streamID: binary.BigEndian.Uint32(hdr[5:]) & 0x7fffffff,
+		payload:  make([]byte, length),
+	}
+	_, err = io.ReadFull(r, f.payload)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func writeFrame(w io.Writer, typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(payload))
+	buf[0] = byte(len(payload) >> 16)
+	buf[1] = byte(len(payload) >> 8)
+	buf[2] = byte(len(payload))
+	buf[3] = byte(typ)
+	buf[4] = flags
+	binary.BigEndian.PutUint32(buf[5:], streamID&0x7fffffff)
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// stripPadding removes the pad length byte and trailing padding from DATA and HEADERS payloads.
+func stripPadding(f *frame) ([]byte, error) {
+	p := f.payload
+	if !f.has(flagPadded) {
+		return p, nil
+	}
+	if len(p) < 1 {
+		return nil, connError{errCodeProtocol, "padded frame too short"}
+	}
+	padLen := int(p[0])
+	if padLen >= len(p) {
+		return nil, connError{errCodeProtocol, "padding exceeds frame payload"}
+	}
+	return p[1 : len(p)-padLen], nil
+}
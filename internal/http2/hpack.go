@@ -0,0 +1,262 @@
+package http2
+
+import (
+	"fmt"
+)
+
+type headerField struct {
+	name  string
+	value string
+}
+
+func (f headerField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// hpackDecoder decodes header blocks and keeps the dynamic table shared by all
+// header blocks received on one connection.
+type hpackDecoder struct {
+	dynamic    []headerField //newest entry first
+	size       int
+	maxSize    int
+	maxAllowed int
+	maxListLen int
+}
+
+func newHPACKDecoder(tableSize, maxHeaderListSize int) *hpackDecoder {
+	return &hpackDecoder{
+		maxSize:    tableSize,
+		maxAllowed: tableSize,
+		maxListLen: maxHeaderListSize,
+	}
+}
+
+func (d *hpackDecoder) decode(block []byte) ([]headerField, error) {
+	fields := []headerField{}
+	listLen := 0
+	sawField := false
+	for len(block) > 0 {
+		b := block[0]
+		var f headerField
+		var err error
+		switch {
+		case b&0x80 != 0:
+			//indexed header field
+			var idx uint64
+			idx, block, err = decodeInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err = d.at(idx)
+			if err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			//literal with incremental indexing
+			f, block, err = d.decodeLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.add(f)
+		case b&0xe0 == 0x20:
+			//dynamic table size update, only allowed before the first field
+			if sawField {
+				return nil, fmt.Errorf("hpack: table size update after header field")
+			}
+			var size uint64
+			size, block, err = decodeInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxAllowed) {
+				return nil, fmt.Errorf("hpack: table size %d exceeds limit %d", size, d.maxAllowed)
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+		default:
+			//literal without indexing or never indexed
+			f, block, err = d.decodeLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sawField = true
+		listLen += f.size()
+		if d.maxListLen > 0 && listLen > d.maxListLen {
+			return nil, fmt.Errorf("hpack: header list larger than %d bytes", d.maxListLen)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (d *hpackDecoder) decodeLiteral(block []byte, prefix uint8) (headerField, []byte, error) {
+	var f headerField
+	idx, block, err := decodeInt(block, prefix)
+	if err != nil {
+		return f, nil, err
+	}
+	if idx == 0 {
+		f.name, block, err = decodeString(block)
+		if err != nil {
+			return f, nil, err
+		}
+	} else {
+		named, err := d.at(idx)
+		if err != nil {
+			return f, nil, err
+		}
+		f.name = named.name
+	}
+	f.value, block, err = decodeString(block)
+	if err != nil {
+		return f, nil, err
+	}
+	return f, block, nil
+}
+
+func (d *hpackDecoder) at(idx uint64) (headerField, error) {
+	if idx == 0 {
+		return headerField{}, fmt.Errorf("hpack: invalid index 0")
+	}
+	if idx < uint64(len(staticTable)) {
+		return staticTable[idx], nil
+	}
+	idx -= uint64(len(staticTable))
+	if idx >= uint64(len(d.dynamic)) {
+		return headerField{}, fmt.Errorf("hpack: index %d out of range", idx+uint64(len(staticTable)))
+	}
+	return d.dynamic[idx], nil
+}
+
+func (d *hpackDecoder) add(f headerField) {
+	if f.size() > d.maxSize {
+		//an entry larger than the table empties it
+		d.dynamic = d.dynamic[:0]
+		d.size = 0
+		return
+	}
+	d.dynamic = append([]headerField{f}, d.dynamic...)
+	d.size += f.size()
+	d.evict()
+}
+
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		last := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= last.size()
+	}
+}
+
+func decodeInt(data []byte, prefix uint8) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, fmt.Errorf("hpack: truncated integer")
+	}
+	max := uint64(1)<<prefix - 1
+	v := uint64(data[0]) & max
+	data = data[1:]
+	if v < max {
+		return v, data, nil
+	}
+	var shift uint
+	for len(data) > 0 {
+		b := data[0]
+		data = data[1:]
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, data, nil
+		}
+		shift += 7
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("hpack: integer overflow")
+		}
+	}
+	return 0, nil, fmt.Errorf("hpack: truncated integer")
+}
+
+func decodeString(data []byte) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, fmt.Errorf("hpack: truncated string")
+	}
+	huffman := data[0]&0x80 != 0
+	n, data, err := decodeInt(data, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(data)) {
+		return "", nil, fmt.Errorf("hpack: string length %d exceeds block", n)
+	}
+	raw := data[:n]
+	data = data[n:]
+	if !huffman {
+		return string(raw), data, nil
+	}
+	s, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, data, nil
+}
+
+// hpackEncode encodes fields without touching the dynamic table, so the
+// encoder needs no state and never has to track the peer's table size.
+func hpackEncode(fields []headerField) []byte {
+	var dst []byte
+	for _, f := range fields {
+		nameIdx := 0
+		exactIdx := 0
+		for i := 1; i < len(staticTable); i++ {
+			if staticTable[i].name != f.name {
+				continue
+			}
+			if nameIdx == 0 {
+				nameIdx = i
+			}
+			if staticTable[i].value == f.value {
+				exactIdx = i
+				break
+			}
+		}
+		if exactIdx != 0 {
+			dst = encodeInt(dst, 0x80, 7, uint64(exactIdx))
+			continue
+		}
+
+		//literal without indexing, or never indexed for sensitive values
+		flag := byte(0x00)
+		if f.name == "authorization" || f.name == "set-cookie" || f.name == "cookie" {
+			flag = 0x10
+		}
+		dst = encodeInt(dst, flag, 4, uint64(nameIdx))
+		if nameIdx == 0 {
+			dst = encodeString(dst, f.name)
+		}
+		dst = encodeString(dst, f.value)
+	}
+	return dst
+}
+
+func encodeInt(dst []byte, flags byte, prefix uint8, v uint64) []byte {
+	max := uint64(1)<<prefix - 1
+	if v < max {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(max))
+	v -= max
+	for v >= 0x80 {
+		dst = append(dst, byte(v&0x7f)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+func encodeString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = encodeInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = encodeInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
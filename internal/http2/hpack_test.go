@@ -0,0 +1,62 @@
+package http2
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHPACKDecode(t *testing.T) {
+	//Test: RFC 7541 C.4.1 first request with huffman coding
+	d := newHPACKDecoder(defaultHeaderTableSz, 0)
+	block, _ := hex.DecodeString("828684418cf1e3c2e5f23a6ba0ab90f4ff")
+	fields, err := d.decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, []headerField{
+		{":method", "GET"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", "www.example.com"},
+	}, fields)
+	assert.Equal(t, 57, d.size)
+
+	//Test: RFC 7541 C.4.2 second request reuses the dynamic table
+	block, _ = hex.DecodeString("828684be5886a8eb10649cbf")
+	fields, err = d.decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, headerField{":authority", "www.example.com"}, fields[3])
+	assert.Equal(t, headerField{"cache-control", "no-cache"}, fields[4])
+	assert.Equal(t, 110, d.size)
+
+	//Test: Index out of range
+	_, err = d.decode([]byte{0xff, 0x10})
+	require.Error(t, err)
+
+	//Test: Table size update after a header field
+	_, err = d.decode([]byte{0x82, 0x20})
+	require.Error(t, err)
+
+	//Test: Table size update above the advertised limit
+	_, err = d.decode([]byte{0x3f, 0xe2, 0x1f})
+	require.Error(t, err)
+
+	//Test: Huffman string with invalid padding
+	_, err = d.decode([]byte{0x00, 0x81, 0x00, 0x00})
+	require.Error(t, err)
+}
+
+func TestHPACKRoundTrip(t *testing.T) {
+	fields := []headerField{
+		{":status", "200"},
+		{"content-type", "text/html"},
+		{"x-custom-header", "some value with spaces"},
+		{"set-cookie", "a=b"},
+		{"x-long", string(make([]byte, 300))},
+	}
+	d := newHPACKDecoder(defaultHeaderTableSz, 0)
+	got, err := d.decode(hpackEncode(fields))
+	require.NoError(t, err)
+	assert.Equal(t, fields, got)
+}
@@ -0,0 +1,78 @@
+package http2
+
+import (
+	"fmt"
+)
+
+type huffmanCode struct {
+	code uint32
+	bits uint8
+}
+
+const huffmanEOS = 0x3fffffff
+
+// huffmanLookup maps a (length, code) pair back to the symbol it encodes.
+var huffmanLookup = func() map[uint64]byte {
+	m := make(map[uint64]byte, len(huffmanCodes))
+	for sym, c := range huffmanCodes {
+		m[uint64(c.bits)<<32|uint64(c.code)] = byte(sym)
+	}
+	return m
+}()
+
+func huffmanDecode(data []byte) (string, error) {
+	out := make([]byte, 0, len(data)*8/5)
+	var cur uint32
+	var bits uint8
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			cur = cur<<1 | uint32(b>>i&1)
+			bits++
+			if bits < 5 {
+				continue
+			}
+			if sym, ok := huffmanLookup[uint64(bits)<<32|uint64(cur)]; ok {
+				out = append(out, sym)
+				cur, bits = 0, 0
+				continue
+			}
+			if bits == 30 {
+				//only EOS is this long and it must never appear in a string
+				return "", fmt.Errorf("invalid huffman code in header string")
+			}
+		}
+	}
+	//leftover bits must be a prefix of EOS (all ones) and shorter than a byte
+	if bits > 7 || cur != (1<<bits)-1 {
+		return "", fmt.Errorf("invalid huffman padding in header string")
+	}
+	return string(out), nil
+}
+
+func huffmanEncode(dst []byte, s string) []byte {
+	var cur uint64
+	var bits uint
+	for i := 0; i < len(s); i++ {
+		c := huffmanCodes[s[i]]
+		cur = cur<<c.bits | uint64(c.code)
+		bits += uint(c.bits)
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(cur>>bits))
+		}
+	}
+	if bits > 0 {
+		//pad with the most significant bits of EOS
+		cur = cur<<(8-bits) | uint64(huffmanEOS>>(30-(8-bits)))
+		dst = append(dst, byte(cur))
+	}
+	return dst
+}
+
+func huffmanEncodedLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n += int(huffmanCodes[s[i]].bits)
+	}
+	return (n + 7) / 8
+}
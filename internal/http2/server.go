@@ -0,0 +1,952 @@
+package http2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// ClientPreface is the first thing an HTTP/2 client sends on a new connection.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// NextProto is the ALPN protocol identifier for HTTP/2 over TLS.
+const NextProto = "h2"
+
+const (
+	maxConcurrentStreams = 100
+	maxHeaderListSize    = 1 << 20
+	//connRecvWindow is how much request body the client may have in flight
+	//across all its streams
+	connRecvWindow = 1 << 20
+	//shutdownTimeout is how long the final GOAWAY may take to write once
+	//ctx is cancelled
+	shutdownTimeout = time.Second
+)
+
+type Handler func(w *response.Writer, req *request.Request)
+
+type serverConn struct {
+	ctx     context.Context
+	conn    net.Conn
+	handler Handler
+	decoder *hpackDecoder
+
+	//wmu keeps frames from different streams from interleaving on the wire
+	wmu sync.Mutex
+
+	//mu guards everything below; cond is signalled when send windows grow, body
+	//data arrives or streams close
+	mu           sync.Mutex
+	cond         *sync.Cond
+	streams      map[uint32]*stream
+	lastStreamID uint32
+	sendWindow   int64
+	recvWindow   int64
+	//recvUnacked counts body bytes read or dropped since the last WINDOW_UPDATE
+	recvUnacked      int64
+	peerMaxFrameSize uint32
+	peerInitWindow   int64
+	closed           bool
+	goingAway        bool
+
+	//header block being assembled from HEADERS and CONTINUATION frames
+	contStreamID uint32
+	headerBlock  []byte
+	endStream    bool
+	refused      bool
+
+	handlers sync.WaitGroup
+}
+
+// ServeConn speaks HTTP/2 on conn until the client goes away. The caller must
+// have negotiated h2 via ALPN or detected the prior-knowledge preface, which
+// ServeConn reads itself. Request contexts derive from ctx and are cancelled
+// when their stream is reset or the connection ends. Handlers start as soon as
+// the request head is in and read the body with req.ReadBody or
+// req.BodyReader; the client is only allowed to send more as they do.
+// Cancelling ctx shuts the connection down with GOAWAY.
+func ServeConn(ctx context.Context, conn net.Conn, handler Handler) {
+	stop := context.AfterFunc(ctx, func() {
+		//unblock the frame loop so it can say goodbye, without waiting on a
+		//client that has stopped reading
+		conn.SetReadDeadline(time.Now())
+		conn.SetWriteDeadline(time.Now().Add(shutdownTimeout))
+	})
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sc := &serverConn{
+		ctx:              ctx,
+		conn:             conn,
+		handler:          handler,
+		decoder:          newHPACKDecoder(defaultHeaderTableSz, maxHeaderListSize),
+		streams:          map[uint32]*stream{},
+		sendWindow:       defaultWindowSize,
+		recvWindow:       defaultWindowSize,
+		peerMaxFrameSize: defaultMaxFrameSize,
+		peerInitWindow:   defaultWindowSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+
+	err := sc.serve()
+	if err != nil && ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, syscall.ECONNRESET) {
+		log.Printf("http2: closing connection from %v: %v", conn.RemoteAddr(), err)
+	}
+
+	sc.mu.Lock()
+	sc.closed = true
+	for _, st := range sc.streams {
+		st.reset = true
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	cancel()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) serve() error {
+	preface := make([]byte, len(ClientPreface))
+	_, err := io.ReadFull(sc.conn, preface)
+	if err != nil {
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return fmt.Errorf("invalid client preface")
+	}
+
+	settings := []byte{}
+	settings = appendSetting(settings, settingMaxConcurrentStreams, maxConcurrentStreams)
+	settings = appendSetting(settings, settingMaxHeaderListSize, maxHeaderListSize)
+	settings = appendSetting(settings, settingEnablePush, 0)
+	err = sc.writeFrame(frameSettings, 0, 0, settings)
+	if err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	sc.recvWindow = connRecvWindow
+	sc.mu.Unlock()
+	err = sc.writeFrame(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, connRecvWindow-defaultWindowSize))
+	if err != nil {
+		return err
+	}
+
+	first := true
+	for {
+		f, err := readFrame(sc.conn, defaultMaxFrameSize)
+		if err == nil && first && (f.typ != frameSettings || f.has(flagAck)) {
+			err = connError{errCodeProtocol, "first frame must be SETTINGS"}
+		}
+		first = false
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+		if err != nil && sc.ctx.Err() != nil {
+			//the server is shutting down, streams already started have been
+			//cancelled with it
+			sc.goAway(errCodeNo, "server shutting down")
+			return nil
+		}
+
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+		var ce connError
+		if errors.As(err, &ce) {
+			sc.goAway(ce.code, ce.reason)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func appendSetting(dst []byte, id settingID, v uint32) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(id))
+	return binary.BigEndian.AppendUint32(dst, v)
+}
+
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	return writeFrame(sc.conn, typ, flags, streamID, payload)
+}
+
+func (sc *serverConn) goAway(code errorCode, reason string) {
+	sc.mu.Lock()
+	last := sc.lastStreamID
+	sc.mu.Unlock()
+	payload := binary.BigEndian.AppendUint32(nil, last)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	sc.writeFrame(frameGoAway, 0, 0, payload)
+}
+
+func (sc *serverConn) resetStream(id uint32, code errorCode) {
+	sc.mu.Lock()
+	if st, ok := sc.streams[id]; ok {
+		st.resetLocked()
+		sc.removeLocked(st)
+	}
+	sc.mu.Unlock()
+	sc.writeFrame(frameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (sc *serverConn) processFrame(f *frame) error {
+	if sc.contStreamID != 0 && (f.typ != frameContinuation || f.streamID != sc.contStreamID) {
+		return connError{errCodeProtocol, "expected CONTINUATION frame"}
+	}
+
+	switch f.typ {
+	case frameData:
+		return sc.processData(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameContinuation:
+		if sc.contStreamID == 0 {
+			return connError{errCodeProtocol, "unexpected CONTINUATION frame"}
+		}
+		if len(sc.headerBlock)+len(f.payload) > maxHeaderListSize {
+			//dropping the block would leave HPACK state out of sync, so the connection goes
+			return connError{errCodeEnhanceYourCalm, "header block too large"}
+		}
+		sc.headerBlock = append(sc.headerBlock, f.payload...)
+		if f.has(flagEndHeaders) {
+			return sc.endHeaderBlock()
+		}
+		return nil
+	case framePriority:
+		if f.streamID == 0 {
+			return connError{errCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.payload) != 5 {
+			return streamError{f.streamID, errCodeFrameSize, "PRIORITY frame must be 5 bytes"}
+		}
+		return nil
+	case frameRSTStream:
+		return sc.processRSTStream(f)
+	case frameSettings:
+		return sc.processSettings(f)
+	case framePushPromise:
+		return connError{errCodeProtocol, "clients must not send PUSH_PROMISE"}
+	case framePing:
+		if f.streamID != 0 {
+			return connError{errCodeProtocol, "PING on non-zero stream"}
+		}
+		if len(f.payload) != 8 {
+			return connError{errCodeFrameSize, "PING frame must be 8 bytes"}
+		}
+		if f.has(flagAck) {
+			return nil
+		}
+		return sc.writeFrame(framePing, flagAck, 0, f.payload)
+	case frameGoAway:
+		if f.streamID != 0 {
+			return connError{errCodeProtocol, "GOAWAY on non-zero stream"}
+		}
+		sc.mu.Lock()
+		sc.goingAway = true
+		sc.mu.Unlock()
+		return nil
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		//unknown frame types must be ignored
+		return nil
+	}
+}
+
+func (sc *serverConn) processSettings(f *frame) error {
+	if f.streamID != 0 {
+		return connError{errCodeProtocol, "SETTINGS on non-zero stream"}
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError{errCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+	if len(f.payload)%6 != 0 {
+		return connError{errCodeFrameSize, "SETTINGS payload not a multiple of 6"}
+	}
+
+	sc.mu.Lock()
+	for p := f.payload; len(p) > 0; p = p[6:] {
+		id := settingID(binary.BigEndian.Uint16(p))
+		v := binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case settingEnablePush:
+			if v > 1 {
+				sc.mu.Unlock()
+				return connError{errCodeProtocol, "invalid ENABLE_PUSH value"}
+			}
+		case settingInitialWindowSize:
+			if v > maxWindowSize {
+				sc.mu.Unlock()
+				return connError{errCodeFlowControl, "INITIAL_WINDOW_SIZE too large"}
+			}
+			delta := int64(v) - sc.peerInitWindow
+			sc.peerInitWindow = int64(v)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					sc.mu.Unlock()
+					return connError{errCodeFlowControl, "stream window overflow"}
+				}
+			}
+		case settingMaxFrameSize:
+			if v < defaultMaxFrameSize || v > maxAllowedFrameSize {
+				sc.mu.Unlock()
+				return connError{errCodeProtocol, "invalid MAX_FRAME_SIZE value"}
+			}
+			sc.peerMaxFrameSize = v
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (sc *serverConn) processWindowUpdate(f *frame) error {
+	if len(f.payload) != 4 {
+		return connError{errCodeFrameSize, "WINDOW_UPDATE frame must be 4 bytes"}
+	}
+	incr := int64(binary.BigEndian.Uint32(f.payload) & 0x7fffffff)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID == 0 {
+		if incr == 0 {
+			return connError{errCodeProtocol, "zero WINDOW_UPDATE increment"}
+		}
+		sc.sendWindow += incr
+		if sc.sendWindow > maxWindowSize {
+			return connError{errCodeFlowControl, "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+	if f.streamID > sc.lastStreamID {
+		return connError{errCodeProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+	st, ok := sc.streams[f.streamID]
+	if !ok {
+		//stream already closed, the update is harmless
+		return nil
+	}
+	if incr == 0 {
+		return streamError{f.streamID, errCodeProtocol, "zero WINDOW_UPDATE increment"}
+	}
+	st.sendWindow += incr
+	if st.sendWindow > maxWindowSize {
+		return streamError{f.streamID, errCodeFlowControl, "stream window overflow"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *frame) error {
+	if f.streamID == 0 {
+		return connError{errCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return connError{errCodeFrameSize, "RST_STREAM frame must be 4 bytes"}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID > sc.lastStreamID {
+		return connError{errCodeProtocol, "RST_STREAM on idle stream"}
+	}
+	if st, ok := sc.streams[f.streamID]; ok {
+		st.resetLocked()
+		sc.removeLocked(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f *frame) error {
+	if f.streamID == 0 {
+		return connError{errCodeProtocol, "DATA on stream 0"}
+	}
+
+	//the whole frame including padding counts against flow control
+	n := int64(len(f.payload))
+	sc.mu.Lock()
+	sc.recvWindow -= n
+	if sc.recvWindow < 0 {
+		sc.mu.Unlock()
+		return connError{errCodeFlowControl, "connection receive window exceeded"}
+	}
+	st, ok := sc.streams[f.streamID]
+	idle := f.streamID > sc.lastStreamID
+	sc.mu.Unlock()
+
+	if idle {
+		return connError{errCodeProtocol, "DATA on idle stream"}
+	}
+	if !ok || st.remoteClosed {
+		//nobody will read it
+		err := sc.releaseRecv(nil, n)
+		if err != nil {
+			return err
+		}
+		return streamError{f.streamID, errCodeStreamClosed, "DATA on closed stream"}
+	}
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	st.recvWindow -= n
+	switch {
+	case st.recvWindow < 0:
+		err = streamError{f.streamID, errCodeFlowControl, "stream receive window exceeded"}
+	case st.contentLength >= 0 && st.received+int64(len(data)) > st.contentLength:
+		err = streamError{f.streamID, errCodeProtocol, "body longer than content-length"}
+	default:
+		st.received += int64(len(data))
+		st.body = append(st.body, data...)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	if err != nil {
+		sc.releaseRecv(nil, n)
+		return err
+	}
+
+	//padding is never read, so it is handed back straight away
+	if pad := n - int64(len(data)); pad > 0 {
+		err = sc.releaseRecv(st, pad)
+		if err != nil {
+			return err
+		}
+	}
+	if f.has(flagEndStream) {
+		return sc.closeRemote(st, nil)
+	}
+	return nil
+}
+
+// releaseRecv gives n bytes back to the client's send windows once the handler
+// has read them or the server has dropped them. st is nil for bytes no stream
+// will read. Updates wait until half a window is owed so an upload costs few
+// WINDOW_UPDATE frames; the client can always send that much meanwhile.
+func (sc *serverConn) releaseRecv(st *stream, n int64) error {
+	var connIncr, streamIncr int64
+	sc.mu.Lock()
+	sc.recvUnacked += n
+	if sc.recvUnacked >= connRecvWindow/2 {
+		connIncr = sc.recvUnacked
+		sc.recvWindow += connIncr
+		sc.recvUnacked = 0
+	}
+	if st != nil && !st.remoteClosed && !st.removed {
+		st.recvUnacked += n
+		if st.recvUnacked >= defaultWindowSize/2 {
+			streamIncr = st.recvUnacked
+			st.recvWindow += streamIncr
+			st.recvUnacked = 0
+		}
+	}
+	sc.mu.Unlock()
+
+	if connIncr > 0 {
+		err := sc.writeFrame(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, uint32(connIncr)))
+		if err != nil {
+			return err
+		}
+	}
+	if streamIncr > 0 {
+		return sc.writeFrame(frameWindowUpdate, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(streamIncr)))
+	}
+	return nil
+}
+
+// closeRemote marks the end of the request body, which then carries trailers.
+func (sc *serverConn) closeRemote(st *stream, trailers headers.Headers) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st.contentLength >= 0 && st.received != st.contentLength {
+		return streamError{st.id, errCodeProtocol, "body does not match content-length"}
+	}
+	st.trailers = trailers
+	st.remoteClosed = true
+	sc.cond.Broadcast()
+	return nil
+}
+
+// removeLocked forgets st, handing body bytes the handler never read back to
+// the connection window. sc.mu must be held.
+func (sc *serverConn) removeLocked(st *stream) {
+	delete(sc.streams, st.id)
+	sc.recvUnacked += int64(len(st.body))
+	st.body = nil
+	st.removed = true
+	sc.cond.Broadcast()
+}
+
+func (sc *serverConn) processHeaders(f *frame) error {
+	if f.streamID == 0 {
+		return connError{errCodeProtocol, "HEADERS on stream 0"}
+	}
+	block, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	if f.has(flagPriority) {
+		if len(block) < 5 {
+			return connError{errCodeProtocol, "HEADERS priority fields truncated"}
+		}
+		block = block[5:]
+	}
+
+	sc.mu.Lock()
+	st, ok := sc.streams[f.streamID]
+	if !ok {
+		if f.streamID%2 == 0 || f.streamID <= sc.lastStreamID {
+			sc.mu.Unlock()
+			return connError{errCodeProtocol, "invalid stream identifier"}
+		}
+		sc.lastStreamID = f.streamID
+		sc.refused = len(sc.streams) >= maxConcurrentStreams || sc.goingAway
+		if !sc.refused {
+			sc.streams[f.streamID] = &stream{
+				id:         f.streamID,
+				sc:         sc,
+				sendWindow: sc.peerInitWindow,
+				recvWindow: defaultWindowSize,
+			}
+		}
+	} else {
+		sc.refused = false
+		if st.remoteClosed {
+			sc.mu.Unlock()
+			return streamError{f.streamID, errCodeStreamClosed, "HEADERS on half-closed stream"}
+		}
+		if !f.has(flagEndStream) {
+			sc.mu.Unlock()
+			return connError{errCodeProtocol, "trailers must end the stream"}
+		}
+	}
+	sc.mu.Unlock()
+
+	sc.contStreamID = f.streamID
+	sc.headerBlock = append(sc.headerBlock[:0], block...)
+	sc.endStream = f.has(flagEndStream)
+	if f.has(flagEndHeaders) {
+		return sc.endHeaderBlock()
+	}
+	return nil
+}
+
+func (sc *serverConn) endHeaderBlock() error {
+	id := sc.contStreamID
+	sc.contStreamID = 0
+
+	//the block must be decoded even for refused streams to keep HPACK state in sync
+	fields, err := sc.decoder.decode(sc.headerBlock)
+	if err != nil {
+		return connError{errCodeCompression, err.Error()}
+	}
+	if sc.refused {
+		return streamError{id, errCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	sc.mu.Lock()
+	st, ok := sc.streams[id]
+	sc.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if st.req != nil {
+		//the handler is already running, so trailers wait for it to reach the
+		//end of the body
+		trailers := headers.NewHeaders()
+		for _, f := range fields {
+			if strings.HasPrefix(f.name, ":") {
+				return streamError{id, errCodeProtocol, "pseudo-header in trailers"}
+			}
+			trailers.Set(f.name, f.value)
+		}
+		return sc.closeRemote(st, trailers)
+	}
+
+	req, err := requestFromFields(fields)
+	if err != nil {
+		return streamError{id, errCodeProtocol, err.Error()}
+	}
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	st.contentLength = -1
+	if cl, ok := req.Headers.Get("Content-Length"); ok {
+		n, err := strconv.ParseUint(cl, 10, 63)
+		if err != nil {
+			return streamError{id, errCodeProtocol, "invalid content-length"}
+		}
+		st.contentLength = int64(n)
+	}
+	st.req = req
+	if sc.endStream {
+		err = sc.closeRemote(st, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		req.SetBodySource(streamBody{st})
+	}
+	return sc.dispatch(st)
+}
+
+// dispatch runs the handler as soon as the request head is in. It reads the
+// body from the stream while the client is still sending it.
+func (sc *serverConn) dispatch(st *stream) error {
+	ctx, cancel := context.WithCancel(sc.ctx)
+	st.req = st.req.WithContext(ctx)
+	sc.mu.Lock()
+	st.cancel = cancel
+	sc.mu.Unlock()
+
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer cancel()
+		defer func() {
+			//a panic must not take the other streams down with it
+			if v := recover(); v != nil {
+				if v != response.ErrAbortHandler {
+					log.Printf("http2: panic serving stream %d: %v\n%s", st.id, v, debug.Stack())
+				}
+				sc.resetStream(st.id, errCodeInternal)
+			}
+		}()
+		w := response.NewTransportWriter(st)
+		w.SetMethod(st.req.RequestLine.Method)
+		sc.handler(w, st.req)
+		st.finish()
+	}()
+	return nil
+}
+
+// connection-specific headers are not allowed in HTTP/2
+var connectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+func requestFromFields(fields []headerField) (*request.Request, error) {
+	req := &request.Request{
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+	}
+	req.RequestLine.HttpVersion = "2"
+	var scheme, authority string
+	regular := false
+	for _, f := range fields {
+		if f.name != strings.ToLower(f.name) {
+			return nil, fmt.Errorf("uppercase header name: %s", f.name)
+		}
+		if strings.HasPrefix(f.name, ":") {
+			if regular {
+				return nil, fmt.Errorf("pseudo-header after regular header: %s", f.name)
+			}
+			var dst *string
+			switch f.name {
+			case ":method":
+				dst = &req.RequestLine.Method
+			case ":path":
+				dst = &req.RequestLine.RequestTarget
+			case ":scheme":
+				dst = &scheme
+			case ":authority":
+				dst = &authority
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header: %s", f.name)
+			}
+			if *dst != "" {
+				return nil, fmt.Errorf("duplicate pseudo-header: %s", f.name)
+			}
+			*dst = f.value
+			continue
+		}
+		regular = true
+		for _, h := range connectionHeaders {
+			if f.name == h {
+				return nil, fmt.Errorf("connection-specific header: %s", f.name)
+			}
+		}
+		if f.name == "te" && f.value != "trailers" {
+			return nil, fmt.Errorf("invalid te header: %s", f.value)
+		}
+		req.Headers.Set(f.name, f.value)
+	}
+
+	if req.RequestLine.Method == "" {
+		return nil, fmt.Errorf("missing :method pseudo-header")
+	}
+	if req.RequestLine.Method == "CONNECT" {
+		if authority == "" || scheme != "" || req.RequestLine.RequestTarget != "" {
+			return nil, fmt.Errorf("malformed CONNECT request")
+		}
+		req.RequestLine.RequestTarget = authority
+	} else if scheme == "" || req.RequestLine.RequestTarget == "" {
+		return nil, fmt.Errorf("missing :scheme or :path pseudo-header")
+	}
+	target, err := request.ParseTarget(req.RequestLine.Method, req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	req.Target = target
+	if _, ok := req.Headers.Get("Host"); !ok && authority != "" {
+		req.Headers.Set("Host", authority)
+	}
+	host, _ := req.Headers.Get("Host")
+	if authority != "" {
+		host = authority
+	}
+	req.Host, req.Port, err = request.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+type stream struct {
+	id  uint32
+	sc  *serverConn
+	req *request.Request
+	//contentLength is -1 when the request has none
+	contentLength int64
+
+	//guarded by sc.mu; only the connection's read loop writes remoteClosed
+	body         []byte
+	received     int64
+	recvWindow   int64
+	recvUnacked  int64
+	remoteClosed bool
+	trailers     headers.Headers
+	sendWindow   int64
+	reset        bool
+	//removed is set once the stream is closed or reset
+	removed bool
+	cancel  context.CancelFunc
+
+	//only touched by the handler goroutine
+	headersSent bool
+	ended       bool
+}
+
+// streamBody is the source of a request body that arrives in DATA frames.
+type streamBody struct {
+	st *stream
+}
+
+func (b streamBody) Read(p []byte) (int, error) {
+	st := b.st
+	sc := st.sc
+	sc.mu.Lock()
+	for len(st.body) == 0 && !st.remoteClosed && !st.removed && !sc.closed {
+		sc.cond.Wait()
+	}
+	if len(st.body) == 0 {
+		defer sc.mu.Unlock()
+		if !st.remoteClosed {
+			return 0, fmt.Errorf("http2 stream %d reset before the body ended", st.id)
+		}
+		for k, v := range st.trailers {
+			st.req.Trailers.Set(k, v)
+		}
+		st.trailers = nil
+		return 0, io.EOF
+	}
+	n := copy(p, st.body)
+	st.body = st.body[n:]
+	sc.mu.Unlock()
+	//a failed WINDOW_UPDATE means the connection is gone, which the read loop sees
+	sc.releaseRecv(st, int64(n))
+	return n, nil
+}
+
+// WriteHeaders implements response.Transport.
+func (st *stream) WriteHeaders(statusCode response.StatusCode, h headers.Headers) error {
+	err := st.writeHeaderBlock(responseFields(statusCode, h), false)
+	if err != nil {
+		return err
+	}
+	st.headersSent = true
+	return nil
+}
+
+// WriteInterim implements response.Transport. A 1xx response is a HEADERS
+// frame of its own ahead of the final one.
+func (st *stream) WriteInterim(statusCode response.StatusCode, h headers.Headers) error {
+	return st.writeHeaderBlock(responseFields(statusCode, h), false)
+}
+
+func responseFields(statusCode response.StatusCode, h headers.Headers) []headerField {
+	fields := []headerField{{":status", fmt.Sprintf("%d", statusCode)}}
+	for k := range h {
+		name := strings.ToLower(k)
+		if isConnectionHeader(name) {
+			continue
+		}
+		for _, value := range h.Values(name) {
+			fields = append(fields, headerField{name, value})
+		}
+	}
+	return fields
+}
+
+// WriteData implements response.Transport, splitting p to fit frame size and flow-control windows.
+func (st *stream) WriteData(p []byte) error {
+	sc := st.sc
+	for len(p) > 0 {
+		sc.mu.Lock()
+		for !st.reset && !sc.closed && (sc.sendWindow <= 0 || st.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if st.reset || sc.closed {
+			sc.mu.Unlock()
+			return fmt.Errorf("http2 stream %d closed", st.id)
+		}
+		n := int64(len(p))
+		n = min(n, sc.sendWindow, st.sendWindow, int64(sc.peerMaxFrameSize))
+		sc.sendWindow -= n
+		st.sendWindow -= n
+		sc.mu.Unlock()
+
+		err := sc.writeFrame(frameData, 0, st.id, p[:n])
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// WriteTrailers implements response.Transport and ends the stream.
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	if len(h) == 0 {
+		return st.end()
+	}
+	fields := []headerField{}
+	for k, v := range h {
+		fields = append(fields, headerField{strings.ToLower(k), v})
+	}
+	err := st.writeHeaderBlock(fields, true)
+	if err != nil {
+		return err
+	}
+	st.ended = true
+	st.close()
+	return nil
+}
+
+func (st *stream) writeHeaderBlock(fields []headerField, endStream bool) error {
+	sc := st.sc
+	block := hpackEncode(fields)
+
+	sc.mu.Lock()
+	maxSize := int(sc.peerMaxFrameSize)
+	reset := st.reset
+	sc.mu.Unlock()
+	if reset {
+		return fmt.Errorf("http2 stream %d closed", st.id)
+	}
+
+	//the whole block must go out without other frames in between
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	typ := frameHeaders
+	flags := uint8(0)
+	if endStream {
+		flags |= flagEndStream
+	}
+	for {
+		chunk := block
+		if len(chunk) > maxSize {
+			chunk = chunk[:maxSize]
+		}
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		err := writeFrame(sc.conn, typ, flags, st.id, chunk)
+		if err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ = frameContinuation
+		flags = 0
+	}
+}
+
+func (st *stream) end() error {
+	if st.ended {
+		return nil
+	}
+	st.ended = true
+	defer st.close()
+	return st.sc.writeFrame(frameData, flagEndStream, st.id, nil)
+}
+
+// finish runs after the handler returns and makes sure the stream is terminated.
+func (st *stream) finish() {
+	st.sc.mu.Lock()
+	reset := st.reset
+	remoteClosed := st.remoteClosed
+	st.sc.mu.Unlock()
+	if reset {
+		return
+	}
+	if !st.headersSent {
+		//the handler never responded
+		st.sc.resetStream(st.id, errCodeInternal)
+		return
+	}
+	st.end()
+	if !remoteClosed {
+		//the response is complete, so the rest of the body is not wanted
+		st.sc.writeFrame(frameRSTStream, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(errCodeNo)))
+	}
+}
+
+// resetLocked marks the stream reset and cancels its request context. sc.mu must be held.
+func (st *stream) resetLocked() {
+	st.reset = true
+	if st.cancel != nil {
+		st.cancel()
+	}
+}
+
+func (st *stream) close() {
+	st.sc.mu.Lock()
+	st.sc.removeLocked(st)
+	st.sc.mu.Unlock()
+}
+
+func isConnectionHeader(name string) bool {
+	for _, h := range connectionHeaders {
+		if name == h {
+			return true
+		}
+	}
+	return false
+}
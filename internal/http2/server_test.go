@@ -0,0 +1,345 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConn is the client end of a connection served by ServeConn.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	frames chan *frame
+}
+
+func newTestConn(t *testing.T, handler Handler) *testConn {
+	return newTestConnContext(t, context.Background(), handler)
+}
+
+func newTestConnContext(t *testing.T, ctx context.Context, handler Handler) *testConn {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeConn(ctx, server, handler)
+		server.Close()
+	}()
+	tc := &testConn{t: t, conn: client, frames: make(chan *frame, 100)}
+	go func() {
+		defer close(tc.frames)
+		for {
+			f, err := readFrame(client, maxAllowedFrameSize)
+			if err != nil {
+				return
+			}
+			tc.frames <- f
+		}
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return tc
+}
+
+// handshake sends the preface and an empty SETTINGS frame and reads the
+// server's side of the exchange.
+func (tc *testConn) handshake() {
+	tc.t.Helper()
+	_, err := tc.conn.Write([]byte(ClientPreface))
+	require.NoError(tc.t, err)
+	tc.write(frameSettings, 0, 0, nil)
+	tc.expect(frameSettings, 0)
+	tc.expect(frameWindowUpdate, 0)
+	f := tc.expect(frameSettings, 0)
+	require.True(tc.t, f.has(flagAck))
+}
+
+func (tc *testConn) write(typ frameType, flags uint8, streamID uint32, payload []byte) {
+	tc.t.Helper()
+	require.NoError(tc.t, writeFrame(tc.conn, typ, flags, streamID, payload))
+}
+
+func (tc *testConn) request(streamID uint32, method string, endStream bool, extra ...headerField) {
+	tc.t.Helper()
+	fields := append([]headerField{
+		{":method", method},
+		{":scheme", "https"},
+		{":path", "/"},
+		{":authority", "localhost"},
+	}, extra...)
+	flags := flagEndHeaders
+	if endStream {
+		flags |= flagEndStream
+	}
+	tc.write(frameHeaders, flags, streamID, hpackEncode(fields))
+}
+
+// next returns the next frame the server sends, or nil once it closes the connection.
+func (tc *testConn) next() *frame {
+	tc.t.Helper()
+	select {
+	case f := <-tc.frames:
+		return f
+	case <-time.After(2 * time.Second):
+		tc.t.Fatal("timed out waiting for a frame")
+		return nil
+	}
+}
+
+func (tc *testConn) expect(typ frameType, streamID uint32) *frame {
+	tc.t.Helper()
+	f := tc.next()
+	require.NotNil(tc.t, f, "connection closed")
+	require.Equal(tc.t, typ, f.typ, "frame type")
+	require.Equal(tc.t, streamID, f.streamID, "stream id")
+	return f
+}
+
+// expectGoAway checks the connection ends with GOAWAY and code.
+func (tc *testConn) expectGoAway(code errorCode) {
+	tc.t.Helper()
+	f := tc.expect(frameGoAway, 0)
+	assert.Equal(tc.t, code, errorCode(binary.BigEndian.Uint32(f.payload[4:])))
+	assert.Nil(tc.t, tc.next())
+}
+
+func (tc *testConn) expectReset(streamID uint32, code errorCode) {
+	tc.t.Helper()
+	f := tc.expect(frameRSTStream, streamID)
+	assert.Equal(tc.t, code, errorCode(binary.BigEndian.Uint32(f.payload)))
+}
+
+// sync waits for a PING round trip, so every frame sent before it has been processed.
+func (tc *testConn) sync() {
+	tc.t.Helper()
+	tc.write(framePing, 0, 0, []byte("syncsync"))
+	f := tc.expect(framePing, 0)
+	require.True(tc.t, f.has(flagAck))
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body, err := io.ReadAll(req.BodyReader())
+	if err != nil {
+		return
+	}
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestPreface(t *testing.T) {
+	//Test: A bad preface closes the connection without a word
+	tc := newTestConn(t, echoHandler)
+	_, err := tc.conn.Write([]byte(strings.Repeat("x", len(ClientPreface))))
+	require.NoError(t, err)
+	assert.Nil(t, tc.next())
+
+	//Test: The first frame must be SETTINGS
+	tc = newTestConn(t, echoHandler)
+	_, err = tc.conn.Write([]byte(ClientPreface))
+	require.NoError(t, err)
+	tc.expect(frameSettings, 0)
+	tc.expect(frameWindowUpdate, 0)
+	tc.write(framePing, 0, 0, []byte("12345678"))
+	tc.expectGoAway(errCodeProtocol)
+}
+
+func TestSettings(t *testing.T) {
+	tc := newTestConn(t, echoHandler)
+	_, err := tc.conn.Write([]byte(ClientPreface))
+	require.NoError(t, err)
+	tc.write(frameSettings, 0, 0, nil)
+
+	//Test: The server announces its limits and a larger connection window
+	f := tc.expect(frameSettings, 0)
+	settings := map[settingID]uint32{}
+	for p := f.payload; len(p) >= 6; p = p[6:] {
+		settings[settingID(binary.BigEndian.Uint16(p))] = binary.BigEndian.Uint32(p[2:])
+	}
+	assert.Equal(t, uint32(maxHeaderListSize), settings[settingMaxHeaderListSize])
+	assert.Equal(t, uint32(maxConcurrentStreams), settings[settingMaxConcurrentStreams])
+	assert.Equal(t, uint32(0), settings[settingEnablePush])
+	f = tc.expect(frameWindowUpdate, 0)
+	assert.Equal(t, uint32(connRecvWindow-defaultWindowSize), binary.BigEndian.Uint32(f.payload))
+	f = tc.expect(frameSettings, 0)
+	assert.True(t, f.has(flagAck))
+
+	//Test: Malformed SETTINGS end the connection
+	tc.write(frameSettings, 0, 0, []byte{0, 1, 0})
+	tc.expectGoAway(errCodeFrameSize)
+}
+
+func TestRequestBody(t *testing.T) {
+	trailer := make(chan string, 1)
+	tc := newTestConn(t, func(w *response.Writer, req *request.Request) {
+		echoHandler(w, req)
+		v, _ := req.Trailers.Get("X-Checksum")
+		trailer <- v
+	})
+	tc.handshake()
+
+	//Test: The body streams to the handler and trailers come after it
+	tc.request(1, "POST", false)
+	tc.write(frameData, flagPadded, 1, append([]byte{3, 'h', 'e', 'l', 'l', 'o'}, 0, 0, 0))
+	tc.write(frameData, 0, 1, []byte(" world"))
+	tc.write(frameHeaders, flagEndHeaders|flagEndStream, 1, hpackEncode([]headerField{{"x-checksum", "abc"}}))
+	tc.expect(frameHeaders, 1)
+	f := tc.expect(frameData, 1)
+	assert.Equal(t, "hello world", string(f.payload))
+	f = tc.expect(frameData, 1)
+	assert.True(t, f.has(flagEndStream))
+	assert.Equal(t, "abc", <-trailer)
+
+	//Test: A body that overruns its Content-Length resets the stream
+	tc.request(3, "POST", false, headerField{"content-length", "2"})
+	tc.write(frameData, 0, 3, []byte("abc"))
+	tc.expectReset(3, errCodeProtocol)
+}
+
+func TestFlowControl(t *testing.T) {
+	release := make(chan struct{})
+	tc := newTestConn(t, func(w *response.Writer, req *request.Request) {
+		if _, ok := req.Headers.Get("X-Never-Read"); ok {
+			<-req.Context().Done()
+			return
+		}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return
+		}
+		body := make([]byte, 40000)
+		_, err := io.ReadFull(req.BodyReader(), body)
+		if err != nil {
+			return
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	tc.handshake()
+	frame := bytes.Repeat([]byte("a"), defaultMaxFrameSize)
+
+	//Test: Nothing is credited back until the handler reads the body
+	tc.request(1, "POST", false)
+	tc.write(frameData, 0, 1, frame)
+	tc.write(frameData, 0, 1, frame)
+	tc.write(frameData, 0, 1, frame[:40000-2*defaultMaxFrameSize])
+	tc.sync()
+
+	//Test: Reading gives the window back, and the unread rest is refused
+	close(release)
+	f := tc.expect(frameWindowUpdate, 1)
+	assert.GreaterOrEqual(t, binary.BigEndian.Uint32(f.payload), uint32(defaultWindowSize/2))
+	tc.expect(frameHeaders, 1)
+	f = tc.expect(frameData, 1)
+	assert.True(t, f.has(flagEndStream))
+	tc.expectReset(1, errCodeNo)
+
+	//Test: Sending past the stream window resets the stream
+	tc.request(3, "POST", false, headerField{"x-never-read", "1"})
+	tc.sync()
+	for range 3 {
+		tc.write(frameData, 0, 3, frame)
+	}
+	tc.write(frameData, 0, 3, frame[:defaultWindowSize-3*defaultMaxFrameSize])
+	tc.sync()
+	tc.write(frameData, 0, 3, []byte("x"))
+	tc.expectReset(3, errCodeFlowControl)
+}
+
+func TestContinuation(t *testing.T) {
+	tc := newTestConn(t, echoHandler)
+	tc.handshake()
+
+	//Test: A header block split over CONTINUATION frames is put back together
+	block := hpackEncode([]headerField{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":path", "/"},
+		{":authority", "localhost"},
+	})
+	tc.write(frameHeaders, flagEndStream, 1, block[:3])
+	tc.write(frameContinuation, 0, 1, block[3:6])
+	tc.write(frameContinuation, flagEndHeaders, 1, block[6:])
+	tc.expect(frameHeaders, 1)
+	tc.expect(frameData, 1)
+
+	//Test: Frames from other streams can't interleave with a header block
+	tc.write(frameHeaders, flagEndStream, 3, block[:3])
+	tc.request(5, "GET", true)
+	tc.expectGoAway(errCodeProtocol)
+
+	//Test: An endless header block is cut off
+	tc = newTestConn(t, echoHandler)
+	tc.handshake()
+	tc.write(frameHeaders, 0, 1, block)
+	chunk := make([]byte, defaultMaxFrameSize)
+	for range maxHeaderListSize / defaultMaxFrameSize {
+		tc.write(frameContinuation, 0, 1, chunk)
+	}
+	tc.expectGoAway(errCodeEnhanceYourCalm)
+}
+
+func TestRSTStream(t *testing.T) {
+	cancelled := make(chan struct{})
+	tc := newTestConn(t, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		close(cancelled)
+	})
+	tc.handshake()
+
+	//Test: RST_STREAM cancels the handler's context
+	tc.request(1, "GET", true)
+	tc.write(frameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(errCodeCancel)))
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+
+	//Test: DATA after the reset is refused
+	tc.write(frameData, 0, 1, []byte("late"))
+	tc.expectReset(1, errCodeStreamClosed)
+
+	//Test: RST_STREAM on stream 0 or an idle stream ends the connection
+	tc.write(frameRSTStream, 0, 0, binary.BigEndian.AppendUint32(nil, uint32(errCodeCancel)))
+	tc.expectGoAway(errCodeProtocol)
+}
+
+func TestShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	tc := newTestConnContext(t, ctx, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+	})
+	tc.handshake()
+	tc.request(1, "GET", true)
+	<-started
+
+	//Test: Cancelling the context cancels handlers, sends GOAWAY and closes
+	cancel()
+	var goAway *frame
+	for f := tc.next(); f != nil; f = tc.next() {
+		//frames for the cancelled stream may come either side of it
+		if f.typ == frameGoAway {
+			goAway = f
+		}
+	}
+	require.NotNil(t, goAway, "connection closed without GOAWAY")
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(goAway.payload))
+	assert.Equal(t, errCodeNo, errorCode(binary.BigEndian.Uint32(goAway.payload[4:])))
+}
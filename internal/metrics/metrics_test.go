@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests served.\nBy path.", "path", "code")
+	inFlight := r.NewGauge("in_flight", `Requests in flight, C:\ style.`)
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a\"\n", "500")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	latency.Observe(0.1, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(3, "/a")
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	assert.Equal(t, `# HELP requests_total Requests served.\nBy path.
+# TYPE requests_total counter
+requests_total{path="/a\"\n",code="500"} 2
+requests_total{path="/b",code="200"} 1
+# HELP in_flight Requests in flight, C:\\ style.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 1
+latency_seconds_bucket{path="/a",le="1"} 2
+latency_seconds_bucket{path="/a",le="+Inf"} 3
+latency_seconds_sum{path="/a"} 3.6
+latency_seconds_count{path="/a"} 3
+`, b.String())
+}
+
+func TestMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("c_total", "C.", "a")
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "x") })
+	assert.Panics(t, func() { r.NewGauge("c_total", "Again.") })
+	assert.Panics(t, func() { r.NewHistogram("h", "H.", []float64{1, 0.5}) })
+}
@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/JA50N14/httpfromtcp/internal/server"
+)
+
+type Algorithm int
+
+const (
+	//TokenBucket lets a client burst up to Limit requests, then refills at
+	//Limit per Window
+	TokenBucket Algorithm = iota
+	//SlidingWindow allows at most Limit requests in any Window, remembering
+	//the time of each one
+	SlidingWindow
+)
+
+// KeyFunc says which client a request counts against. Requests it returns ""
+// for are not limited.
+type KeyFunc func(req *request.Request) string
+
+// ByIP keys requests by the address of the connection they came in on. IPv6
+// clients are keyed by their /64, since a single host is usually handed a
+// whole one and could otherwise use a fresh address per request.
+func ByIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() != nil {
+		return host
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// ByHeader keys requests by the value of a header such as an API key, falling
+// back to ByIP for requests without it. Clients choose the header's value, so
+// this is only a limit for keys the handler authenticates and refuses when
+// unknown: made-up values each get a fresh quota, and enough of them push
+// real clients out of the limiter's memory. Limit by IP in front of it, with
+// a second Limiter, when the keys are not checked.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		if v, ok := req.Headers.Get(name); ok && v != "" {
+			return name + "=" + v
+		}
+		return ByIP(req)
+	}
+}
+
+type Options struct {
+	Algorithm Algorithm
+	//Limit requests are allowed per Window; for TokenBucket it is also the burst size
+	Limit  int
+	Window time.Duration
+	//Key groups requests by client; nil means ByIP
+	Key KeyFunc
+	//MaxKeys bounds memory: past it the least recently seen client is forgotten
+	MaxKeys int
+}
+
+// Decision is the outcome of counting one request.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	//Reset is how long until the client has its whole quota back
+	Reset time.Duration
+	//RetryAfter is how long a client that was refused has to wait
+	RetryAfter time.Duration
+}
+
+type Limiter struct {
+	opts Options
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	//lru holds *client, most recently seen first
+	lru *list.List
+}
+
+type client struct {
+	key string
+	//tokens and last are the token bucket's state
+	tokens float64
+	last   time.Time
+	//hits are the sliding window's request times, oldest first
+	hits []time.Time
+}
+
+func New(opts Options) (*Limiter, error) {
+	if opts.Limit <= 0 || opts.Window <= 0 {
+		return nil, errors.New("rate limit needs a positive Limit and Window")
+	}
+	if opts.Algorithm != TokenBucket && opts.Algorithm != SlidingWindow {
+		return nil, fmt.Errorf("unknown rate limit algorithm: %d", opts.Algorithm)
+	}
+	if opts.Key == nil {
+		opts.Key = ByIP
+	}
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = 10000
+	}
+	return &Limiter{
+		opts:    opts,
+		clients: map[string]*list.Element{},
+		lru:     list.New(),
+	}, nil
+}
+
+// Allow counts a request from key made at now, unless it is refused.
+func (l *Limiter) Allow(key string, now time.Time) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(key, now)
+	if l.opts.Algorithm == SlidingWindow {
+		return l.slidingWindow(c, now)
+	}
+	return l.tokenBucket(c, now)
+}
+
+// client finds key's state, creating it with a full quota and evicting the
+// least recently seen client if that makes too many.
+func (l *Limiter) client(key string, now time.Time) *client {
+	if e, ok := l.clients[key]; ok {
+		l.lru.MoveToFront(e)
+		return e.Value.(*client)
+	}
+	if l.lru.Len() >= l.opts.MaxKeys {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.clients, oldest.Value.(*client).key)
+	}
+	c := &client{key: key, tokens: float64(l.opts.Limit), last: now}
+	l.clients[key] = l.lru.PushFront(c)
+	return c
+}
+
+func (l *Limiter) tokenBucket(c *client, now time.Time) Decision {
+	limit := float64(l.opts.Limit)
+	perNanosecond := limit / float64(l.opts.Window)
+	if elapsed := now.Sub(c.last); elapsed > 0 {
+		c.tokens = math.Min(limit, c.tokens+float64(elapsed)*perNanosecond)
+		c.last = now
+	}
+	d := Decision{Limit: l.opts.Limit}
+	if c.tokens >= 1 {
+		c.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration(math.Ceil((1 - c.tokens) / perNanosecond))
+	}
+	d.Remaining = int(c.tokens)
+	d.Reset = time.Duration(math.Ceil((limit - c.tokens) / perNanosecond))
+	return d
+}
+
+func (l *Limiter) slidingWindow(c *client, now time.Time) Decision {
+	start := now.Add(-l.opts.Window)
+	expired := 0
+	for expired < len(c.hits) && !c.hits[expired].After(start) {
+		expired++
+	}
+	c.hits = c.hits[expired:]
+
+	d := Decision{Limit: l.opts.Limit}
+	if len(c.hits) < l.opts.Limit {
+		c.hits = append(c.hits, now)
+		d.Allowed = true
+	} else {
+		d.RetryAfter = c.hits[0].Sub(start)
+	}
+	d.Remaining = l.opts.Limit - len(c.hits)
+	if len(c.hits) > 0 {
+		d.Reset = c.hits[len(c.hits)-1].Sub(start)
+	}
+	return d
+}
+
+// Middleware counts every request against its client and refuses the ones
+// over the limit with 429 Too Many Requests and Retry-After. All responses
+// carry the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers of the IETF RateLimit header fields draft.
+func (l *Limiter) Middleware(next server.Handler) server.Handler {
+	policy := fmt.Sprintf("%d;w=%d", l.opts.Limit, seconds(l.opts.Window))
+	return func(w *response.Writer, req *request.Request) {
+		key := l.opts.Key(req)
+		if key == "" {
+			next(w, req)
+			return
+		}
+		d := l.Allow(key, time.Now())
+		retryAfter := max(seconds(d.RetryAfter), 1)
+		w.OnWriteHeaders(func(h headers.Headers) {
+			h.Override("RateLimit-Limit", strconv.Itoa(d.Limit))
+			h.Override("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Override("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+			h.Override("RateLimit-Policy", policy)
+			if !d.Allowed {
+				h.Override("Retry-After", strconv.Itoa(retryAfter))
+			}
+		})
+		if !d.Allowed {
+			server.Error(w, req, response.StatusCodeTooManyRequests, fmt.Sprintf("Try again in %d seconds.", retryAfter))
+			return
+		}
+		next(w, req)
+	}
+}
+
+// seconds rounds d up, so clients that wait that long are let through.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
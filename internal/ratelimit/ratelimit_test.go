@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l, err := New(Options{Algorithm: TokenBucket, Limit: 3, Window: 3 * time.Second})
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+
+	//Test: A full bucket allows a burst of Limit requests
+	for i := 2; i >= 0; i-- {
+		d := l.Allow("a", now)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, i, d.Remaining)
+	}
+	d := l.Allow("a", now)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+	assert.Equal(t, 3*time.Second, d.Reset)
+
+	//Test: Other keys have their own bucket
+	assert.True(t, l.Allow("b", now).Allowed)
+
+	//Test: Tokens come back at Limit per Window
+	assert.False(t, l.Allow("a", now.Add(900*time.Millisecond)).Allowed)
+	d = l.Allow("a", now.Add(1100*time.Millisecond))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.False(t, l.Allow("a", now.Add(1200*time.Millisecond)).Allowed)
+
+	//Test: The bucket never holds more than Limit
+	d = l.Allow("a", now.Add(time.Hour))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 2, d.Remaining)
+	assert.Equal(t, time.Second, d.Reset)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	l, err := New(Options{Algorithm: SlidingWindow, Limit: 2, Window: 10 * time.Second})
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+
+	assert.True(t, l.Allow("a", now).Allowed)
+	d := l.Allow("a", now.Add(4*time.Second))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.Equal(t, 10*time.Second, d.Reset)
+
+	//Test: A refused request waits for the oldest one to leave the window
+	d = l.Allow("a", now.Add(6*time.Second))
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 4*time.Second, d.RetryAfter)
+	assert.Equal(t, 8*time.Second, d.Reset)
+
+	//Test: Refused requests are not counted
+	d = l.Allow("a", now.Add(10*time.Second))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	d = l.Allow("a", now.Add(30*time.Second))
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 1, d.Remaining)
+}
+
+func TestEviction(t *testing.T) {
+	l, err := New(Options{Limit: 1, Window: time.Minute, MaxKeys: 2})
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+
+	assert.True(t, l.Allow("a", now).Allowed)
+	assert.True(t, l.Allow("b", now).Allowed)
+	assert.False(t, l.Allow("a", now).Allowed)
+
+	//Test: The least recently seen key goes first; "b" is forgotten, "a" is not
+	assert.True(t, l.Allow("c", now).Allowed)
+	assert.Equal(t, 2, l.lru.Len())
+	assert.Len(t, l.clients, 2)
+	assert.False(t, l.Allow("a", now).Allowed)
+	assert.True(t, l.Allow("b", now).Allowed)
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Options{Limit: 0, Window: time.Second})
+	assert.Error(t, err)
+	_, err = New(Options{Limit: 1})
+	assert.Error(t, err)
+	_, err = New(Options{Algorithm: Algorithm(7), Limit: 1, Window: time.Second})
+	assert.Error(t, err)
+}
+
+func TestByIP(t *testing.T) {
+	for addr, key := range map[string]string{
+		"192.0.2.1:51000":              "192.0.2.1",
+		"[2001:db8:1:2:3:4:5:6]:51000": "2001:db8:1:2::/64",
+		"[2001:db8:1:2:ffff::1]:443":   "2001:db8:1:2::/64",
+		"[2001:db8:1:3::1]:443":        "2001:db8:1:3::/64",
+		"[::ffff:192.0.2.1]:51000":     "::ffff:192.0.2.1",
+		"not an address":               "not an address",
+	} {
+		req := &request.Request{RemoteAddr: addr}
+		assert.Equal(t, key, ByIP(req), addr)
+	}
+}
+
+func serve(t *testing.T, l *Limiter, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:51000"
+	var out bytes.Buffer
+	l.Middleware(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})(response.NewWriter(&out), req)
+	return strings.ToLower(out.String())
+}
+
+func TestMiddleware(t *testing.T) {
+	l, err := New(Options{Algorithm: SlidingWindow, Limit: 1, Window: time.Minute, Key: ByHeader("X-API-Key")})
+	require.NoError(t, err)
+
+	//Test: Allowed responses carry the quota
+	out := serve(t, l, "GET / HTTP/1.1\r\nHost: localhost\r\nX-API-Key: k1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "http/1.1 200 ok\r\n"), out)
+	assert.Contains(t, out, "ratelimit-limit: 1\r\n")
+	assert.Contains(t, out, "ratelimit-remaining: 0\r\n")
+	assert.Contains(t, out, "ratelimit-reset: 60\r\n")
+	assert.Contains(t, out, "ratelimit-policy: 1;w=60\r\n")
+	assert.NotContains(t, out, "retry-after")
+
+	//Test: Over the limit is a 429 with Retry-After
+	out = serve(t, l, "GET / HTTP/1.1\r\nHost: localhost\r\nX-API-Key: k1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "http/1.1 429 too many requests\r\n"), out)
+	assert.Contains(t, out, "retry-after: 60\r\n")
+	assert.Contains(t, out, "try again in 60 seconds.")
+
+	//Test: Another key, and the client IP when the header is missing, are separate
+	out = serve(t, l, "GET / HTTP/1.1\r\nHost: localhost\r\nX-API-Key: k2\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "http/1.1 200 ok\r\n"), out)
+	out = serve(t, l, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "http/1.1 200 ok\r\n"), out)
+	out = serve(t, l, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "http/1.1 429 too many requests\r\n"), out)
+}
@@ -0,0 +1,24 @@
+package request
+
+import (
+	"github.com/JA50N14/httpfromtcp/internal/cookie"
+)
+
+// Cookies returns the cookies sent in the Cookie header.
+func (r *Request) Cookies() []*cookie.Cookie {
+	header, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return []*cookie.Cookie{}
+	}
+	return cookie.Parse(header)
+}
+
+// Cookie returns the first cookie with the given name.
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,69 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+var ErrNotForm = errors.New("request body is not a form")
+
+// mediaType returns the lower-cased media type and parameters of the Content-Type header.
+func (r *Request) mediaType() (string, map[string]string, error) {
+	ct, ok := r.Headers.Get("Content-Type")
+	if !ok {
+		return "", nil, ErrNotForm
+	}
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid content-type header: %v", err)
+	}
+	return mediaType, params, nil
+}
+
+// Form decodes an application/x-www-form-urlencoded body.
+func (r *Request) Form() (Query, error) {
+	mediaType, _, err := r.mediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil, fmt.Errorf("%w: %s", ErrNotForm, mediaType)
+	}
+	err = r.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	return ParseQuery(strings.TrimSpace(string(r.Body)))
+}
+
+// MultipartReader streams the parts of a multipart/form-data body one at a
+// time. A body that is still unread is read from the connection as the parts
+// are, so only a buffer's worth of it is in memory; one the server already
+// read is served from Body.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := r.mediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/form-data" {
+		return nil, fmt.Errorf("%w: %s", ErrNotForm, mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, fmt.Errorf("invalid multipart boundary: %q", boundary)
+	}
+	return NewMultipartReader(r.BodyReader(), boundary), nil
+}
+
+// ParseMultipartForm reads a whole multipart/form-data body. Files that don't fit
+// in limits.MaxMemory are written to temporary files; call RemoveAll on the form
+// when done with it.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) (*MultipartForm, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	return mr.ReadForm(limits)
+}
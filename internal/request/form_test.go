@@ -0,0 +1,177 @@
+package request
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartBody = "preamble to ignore\r\n" +
+	"--XYZ\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"hello\r\nworld\r\n" +
+	"--XYZ\r\n" +
+	"Content-Disposition: form-data; name=\"upload\"; filename=\"notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"file contents with --XY inside\r\n" +
+	"--XYZ\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"second\r\n" +
+	"--XYZ--\r\n" +
+	"epilogue"
+
+func multipartRequest(t *testing.T, body string) *Request {
+	data := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: multipart/form-data; boundary=XYZ\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" + body
+	r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 5})
+	require.NoError(t, err)
+	return r
+}
+
+func TestForm(t *testing.T) {
+	//Test: URL-encoded form body
+	data := "POST /login HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+		"Content-Length: 27\r\n" +
+		"\r\n" +
+		"user=jason&pass=p%40ss+word"
+	r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 4})
+	require.NoError(t, err)
+	form, err := r.Form()
+	require.NoError(t, err)
+	assert.Equal(t, "jason", form.Get("user"))
+	assert.Equal(t, "p@ss word", form.Get("pass"))
+
+	//Test: Wrong content type
+	_, err = multipartRequest(t, multipartBody).Form()
+	require.ErrorIs(t, err, ErrNotForm)
+}
+
+func TestMultipartReader(t *testing.T) {
+	r := multipartRequest(t, multipartBody)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName)
+	body, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\r\nworld", string(body))
+
+	//Test: Skipping an unread part
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", part.FileName)
+	ct, _ := part.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", ct)
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	body, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(body))
+
+	_, err = mr.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestMultipartReaderStreams(t *testing.T) {
+	//Test: Parts of an unread body come off the connection as they are read
+	big := strings.Repeat("x", 64*1024)
+	body := "--XYZ\r\nContent-Disposition: form-data; name=\"first\"\r\n\r\nsmall\r\n" +
+		"--XYZ\r\nContent-Disposition: form-data; name=\"big\"\r\n\r\n" + big + "\r\n--XYZ--\r\n"
+	conn := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\n" +
+			"Content-Type: multipart/form-data; boundary=XYZ\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body,
+		numBytesPerRead: 1024,
+	}
+	r, err := RequestHeadFromReader(conn)
+	require.NoError(t, err)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+	assert.Less(t, conn.pos, len(conn.data)/2)
+	assert.False(t, r.BodyRead())
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	data, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, big, string(data))
+	_, err = mr.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+	assert.Empty(t, r.Body)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	//Test: Everything in memory
+	form, err := multipartRequest(t, multipartBody).ParseMultipartForm(DefaultMultipartLimits)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello\r\nworld", "second"}, form.Value.Values("title"))
+	require.Len(t, form.File["upload"], 1)
+	fh := form.File["upload"][0]
+	assert.Equal(t, "notes.txt", fh.FileName)
+	assert.Equal(t, int64(30), fh.Size)
+	assert.Empty(t, fh.tempFile)
+
+	//Test: File spills to disk past the memory threshold
+	form, err = multipartRequest(t, multipartBody).ParseMultipartForm(MultipartLimits{MaxMemory: 20})
+	require.NoError(t, err)
+	fh = form.File["upload"][0]
+	require.NotEmpty(t, fh.tempFile)
+	f, err := fh.Open()
+	require.NoError(t, err)
+	contents, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "file contents with --XY inside", string(contents))
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(fh.tempFile)
+	assert.True(t, os.IsNotExist(err))
+
+	//Test: Too many parts
+	_, err = multipartRequest(t, multipartBody).ParseMultipartForm(MultipartLimits{MaxMemory: 1 << 20, MaxParts: 2})
+	require.Error(t, err)
+
+	//Test: Part larger than the limit
+	_, err = multipartRequest(t, multipartBody).ParseMultipartForm(MultipartLimits{MaxMemory: 1 << 20, MaxPartSize: 10})
+	require.Error(t, err)
+
+	//Test: Missing closing boundary
+	_, err = multipartRequest(t, strings.TrimSuffix(multipartBody, "--XYZ--\r\nepilogue")).ParseMultipartForm(DefaultMultipartLimits)
+	require.Error(t, err)
+
+	//Test: Zero limits mean no limit, memory included
+	form, err = multipartRequest(t, multipartBody).ParseMultipartForm(MultipartLimits{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello\r\nworld", "second"}, form.Value.Values("title"))
+	assert.Empty(t, form.File["upload"][0].tempFile)
+
+	//Test: An endless preamble is cut off
+	preamble := strings.Repeat(strings.Repeat("p", 100)+"\r\n", maxPreambleSize/100+1)
+	_, err = multipartRequest(t, preamble+multipartBody).ParseMultipartForm(DefaultMultipartLimits)
+	require.ErrorContains(t, err, "preamble longer than")
+
+	//Test: So is a preamble line that never ends
+	_, err = multipartRequest(t, strings.Repeat("p", 2*ReadBufferSize)+multipartBody).ParseMultipartForm(DefaultMultipartLimits)
+	require.ErrorContains(t, err, "line longer than")
+}
@@ -0,0 +1,95 @@
+package request
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fuzzSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+	"GET / HTTP/1.0\r\n\r\n",
+	"\r\nGET /after-empty-line HTTP/1.1\r\nHost: a\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: a\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Trailer: t\r\n\r\n",
+	"OPTIONS * HTTP/1.1\r\nHost: a\r\n\r\n",
+	"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+	"GET http://example.com/a/../b?q=1#frag HTTP/1.1\r\nHost: ignored\r\n\r\n",
+	"GET /%7Euser/a%2Fb?x=%20y+z HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n",
+	"M-SEARCH * HTTP/1.1\r\nHost: 239.255.255.250:1900\r\n\r\n",
+	"POST /form HTTP/1.1\r\nHost: a\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 7\r\n\r\na=1&b=2",
+	"GET / HTTP/1.1\r\nHost: a\r\nCookie: a=1\r\nCookie: b=2\r\nSet-Cookie: c=3\r\n\r\n",
+	"GET / HTTP/2.0\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: a\r\n",
+	"",
+}
+
+// FuzzRequestFromReader checks the parser never panics, never produces more
+// body than it was given, and reaches the same result however the bytes are
+// split across reads.
+func FuzzRequestFromReader(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, uint8(1))
+		f.Add(seed, uint8(7))
+	}
+	for _, tc := range smugglingPayloads {
+		f.Add(tc.data, uint8(3))
+	}
+
+	f.Fuzz(func(t *testing.T, data string, numBytesPerRead uint8) {
+		if numBytesPerRead == 0 {
+			numBytesPerRead = 1
+		}
+		whole, wholeErr := RequestFromReader(strings.NewReader(data))
+		split, splitErr := RequestFromReader(&chunkReader{data: data, numBytesPerRead: int(numBytesPerRead)})
+		require.Equal(t, wholeErr == nil, splitErr == nil, "whole: %v, split: %v", wholeErr, splitErr)
+		if wholeErr != nil {
+			require.Nil(t, whole)
+			return
+		}
+		assert.LessOrEqual(t, len(whole.Body), len(data))
+		assert.Equal(t, whole.RequestLine, split.RequestLine)
+		assert.Equal(t, whole.Target, split.Target)
+		assert.Equal(t, whole.Headers, split.Headers)
+		assert.Equal(t, whole.Host, split.Host)
+		assert.Equal(t, whole.Port, split.Port)
+		assert.Equal(t, whole.Body, split.Body)
+		assert.Equal(t, whole.Trailers, split.Trailers)
+		assert.True(t, whole.BodyRead())
+	})
+}
+
+// FuzzPipelinedRequests checks that a request is consumed exactly: the bytes it
+// used parse on their own to the same request, and a request sent after them
+// is read intact.
+func FuzzPipelinedRequests(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	const next = "GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	f.Fuzz(func(t *testing.T, data string) {
+		br := bufio.NewReader(strings.NewReader(data))
+		first, err := RequestFromReader(br)
+		if err != nil {
+			return
+		}
+		rest, err := io.ReadAll(br)
+		require.NoError(t, err)
+		consumed := data[:len(data)-len(rest)]
+
+		br = bufio.NewReader(strings.NewReader(consumed + next))
+		again, err := RequestFromReader(br)
+		require.NoError(t, err)
+		assert.Equal(t, first.RequestLine, again.RequestLine)
+		assert.Equal(t, first.Headers, again.Headers)
+		assert.Equal(t, first.Body, again.Body)
+		r, err := RequestFromReader(br)
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+}
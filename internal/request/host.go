@@ -0,0 +1,94 @@
+package request
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// SplitHostPort splits a Host header value or an authority into host and port.
+// The host is lowercased and IPv6 literals lose their brackets. Either part may
+// be empty: an empty Host header is allowed when the target has no authority.
+func SplitHostPort(hostport string) (host, port string, err error) {
+	if hostport == "" {
+		return "", "", nil
+	}
+	if strings.HasPrefix(hostport, "[") {
+		end := strings.Index(hostport, "]")
+		if end == -1 {
+			return "", "", fmt.Errorf("invalid host: missing ] in %q", hostport)
+		}
+		addr, err := netip.ParseAddr(hostport[1:end])
+		if err != nil || !addr.Is6() {
+			return "", "", fmt.Errorf("invalid IPv6 literal in host: %q", hostport)
+		}
+		host = strings.ToLower(hostport[1:end])
+		hostport = hostport[end+1:]
+		if hostport != "" && !strings.HasPrefix(hostport, ":") {
+			return "", "", fmt.Errorf("invalid host: %q", hostport)
+		}
+		port = strings.TrimPrefix(hostport, ":")
+	} else {
+		host, port, _ = strings.Cut(hostport, ":")
+		if !validRegName(host) {
+			return "", "", fmt.Errorf("invalid host: %q", hostport)
+		}
+		host = strings.ToLower(host)
+	}
+
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || port[0] == '+' || port[0] == '-' || n > 65535 {
+			return "", "", fmt.Errorf("invalid port in host: %q", port)
+		}
+	}
+	return host, port, nil
+}
+
+// validRegName checks for unreserved, percent-encoded and sub-delims
+// characters, which also covers IPv4 addresses. Userinfo is not allowed.
+func validRegName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isUnreserved(c):
+		case strings.IndexByte("!$&'()*+,;=", c) >= 0:
+		case c == '%':
+			if _, err := percentByte(s, i); err != nil {
+				return false
+			}
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseHost sets Host and Port. HTTP/1.1 requests need exactly one Host
+// header; for absolute-form and authority-form targets the target's authority
+// takes precedence over it.
+func (r *Request) parseHost() error {
+	value, ok := r.Headers.Get("Host")
+	if !ok && r.RequestLine.HttpVersion == "1.1" {
+		return fmt.Errorf("missing host header")
+	}
+	//repeated headers are joined with commas, which a single host never needs
+	if strings.Contains(value, ",") {
+		return fmt.Errorf("multiple host headers: %s", value)
+	}
+	host, port, err := SplitHostPort(value)
+	if err != nil {
+		return err
+	}
+	if r.Target.Form == TargetFormAbsolute || r.Target.Form == TargetFormAuthority {
+		host, port, err = SplitHostPort(r.Target.Authority)
+		if err != nil {
+			return err
+		}
+	}
+	r.Host = host
+	r.Port = port
+	return nil
+}
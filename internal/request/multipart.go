@@ -0,0 +1,325 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+)
+
+// MultipartLimits bounds what ReadForm accepts. A zero field means no limit.
+type MultipartLimits struct {
+	//MaxMemory is how many bytes of values and files are kept in memory before files spill to disk
+	MaxMemory   int64
+	MaxParts    int
+	MaxPartSize int64
+}
+
+// maxPreambleSize bounds the text before the first boundary, which clients
+// normally leave empty.
+const maxPreambleSize = 64 * 1024
+
+var DefaultMultipartLimits = MultipartLimits{
+	MaxMemory:   32 << 20,
+	MaxParts:    1000,
+	MaxPartSize: 1 << 30,
+}
+
+type MultipartReader struct {
+	reader    *bufio.Reader
+	boundary  string
+	delimiter []byte //CRLF "--" boundary, which ends every part
+	current   *Part
+	started   bool
+	done      bool
+}
+
+type Part struct {
+	Headers  headers.Headers
+	FormName string
+	FileName string
+
+	mr  *MultipartReader
+	eof bool
+}
+
+func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		reader:    bufio.NewReaderSize(r, ReadBufferSize),
+		boundary:  boundary,
+		delimiter: []byte("\r\n--" + boundary),
+	}
+}
+
+// NextPart skips whatever is left of the current part and returns the next
+// one, or io.EOF after the closing boundary.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		_, err := io.Copy(io.Discard, mr.current)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var rest string
+	var err error
+	if !mr.started {
+		rest, err = mr.skipPreamble()
+		mr.started = true
+	} else {
+		_, err = mr.reader.Discard(len(mr.delimiter))
+		if err == nil {
+			rest, err = mr.readLine()
+		}
+	}
+	//the boundary is followed by "--" on the last one, otherwise by the end of line
+	if strings.HasPrefix(rest, "--") {
+		mr.done = true
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("multipart: reading boundary: %w", err)
+	}
+	if strings.TrimRight(rest, " \t\r\n") != "" {
+		return nil, fmt.Errorf("multipart: garbage after boundary: %q", rest)
+	}
+
+	part := &Part{
+		Headers: headers.NewHeaders(),
+		mr:      mr,
+	}
+	err = mr.readPartHeaders(part.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := part.Headers.Get("Content-Disposition"); ok {
+		disposition, params, err := mime.ParseMediaType(cd)
+		if err == nil && disposition == "form-data" {
+			part.FormName = params["name"]
+			part.FileName = params["filename"]
+		}
+	}
+	mr.current = part
+	return part, nil
+}
+
+// skipPreamble discards everything before the first boundary and returns the
+// rest of the boundary line.
+func (mr *MultipartReader) skipPreamble() (string, error) {
+	dashBoundary := "--" + mr.boundary
+	skipped := 0
+	for {
+		line, err := mr.readLine()
+		if rest, ok := strings.CutPrefix(line, dashBoundary); ok {
+			return rest, err
+		}
+		if err != nil {
+			return "", err
+		}
+		skipped += len(line)
+		if skipped > maxPreambleSize {
+			return "", fmt.Errorf("preamble longer than %d bytes", maxPreambleSize)
+		}
+	}
+}
+
+// readLine reads through the next newline, which must come within the read
+// buffer.
+func (mr *MultipartReader) readLine() (string, error) {
+	line, err := mr.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("line longer than %d bytes", mr.reader.Size())
+	}
+	return string(line), err
+}
+
+func (mr *MultipartReader) readPartHeaders(h headers.Headers) error {
+	unparsed := 0
+	for {
+		data, err := mr.reader.Peek(max(mr.reader.Buffered(), unparsed+1))
+		if len(data) > unparsed {
+			n, done, perr := h.Parse(data)
+			if perr != nil {
+				return fmt.Errorf("multipart: %v", perr)
+			}
+			mr.reader.Discard(n)
+			if done {
+				return nil
+			}
+			if n == 0 {
+				unparsed = len(data)
+			} else {
+				unparsed = 0
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("multipart: reading part headers: %w", err)
+		}
+	}
+}
+
+// Read returns the part body up to the next boundary.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	mr := p.mr
+	data, err := mr.reader.Peek(max(mr.reader.Buffered(), len(mr.delimiter)))
+	if idx := bytes.Index(data, mr.delimiter); idx >= 0 {
+		if idx == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		n := copy(b, data[:idx])
+		mr.reader.Discard(n)
+		return n, nil
+	}
+	if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+		if errors.Is(err, io.EOF) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	//the tail could be the start of a delimiter, so only hand out what comes before it
+	safe := len(data) - len(mr.delimiter) + 1
+	n := copy(b, data[:safe])
+	mr.reader.Discard(n)
+	return n, nil
+}
+
+type MultipartForm struct {
+	Value Query
+	File  map[string][]*FileHeader
+}
+
+type FileHeader struct {
+	FileName string
+	Headers  headers.Headers
+	Size     int64
+
+	content  []byte
+	tempFile string
+}
+
+// Open returns the file contents, from memory or from the temporary file it spilled to.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tempFile != "" {
+		return os.Open(fh.tempFile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll deletes the temporary files created for the form.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tempFile != "" {
+				errs = append(errs, os.Remove(fh.tempFile))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (mr *MultipartReader) ReadForm(limits MultipartLimits) (_ *MultipartForm, err error) {
+	form := &MultipartForm{
+		Value: Query{},
+		File:  map[string][]*FileHeader{},
+	}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	memoryLeft := limits.MaxMemory
+	if memoryLeft <= 0 {
+		//one less, so reading a byte past it can't overflow
+		memoryLeft = math.MaxInt64 - 1
+	}
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if limits.MaxParts > 0 && parts >= limits.MaxParts {
+			return nil, fmt.Errorf("multipart: more than %d parts", limits.MaxParts)
+		}
+		if part.FormName == "" {
+			continue
+		}
+
+		var body io.Reader = part
+		if limits.MaxPartSize > 0 {
+			//read one byte past the limit to detect oversized parts
+			body = io.LimitReader(part, limits.MaxPartSize+1)
+		}
+
+		if part.FileName == "" {
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, body, memoryLeft+1)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if limits.MaxPartSize > 0 && n > limits.MaxPartSize {
+				return nil, fmt.Errorf("multipart: part %q larger than %d bytes", part.FormName, limits.MaxPartSize)
+			}
+			if n > memoryLeft {
+				return nil, fmt.Errorf("multipart: form values larger than %d bytes", limits.MaxMemory)
+			}
+			memoryLeft -= n
+			form.Value[part.FormName] = append(form.Value[part.FormName], buf.String())
+			continue
+		}
+
+		fh := &FileHeader{
+			FileName: part.FileName,
+			Headers:  part.Headers,
+		}
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, body, memoryLeft+1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if n > memoryLeft {
+			//too big for memory, move what we have and the rest to a temp file
+			tmp, err := os.CreateTemp("", "multipart-")
+			if err != nil {
+				return nil, err
+			}
+			fh.tempFile = tmp.Name()
+			form.File[part.FormName] = append(form.File[part.FormName], fh)
+			rest, err := io.Copy(tmp, io.MultiReader(&buf, body))
+			closeErr := tmp.Close()
+			if err != nil {
+				return nil, err
+			}
+			if closeErr != nil {
+				return nil, closeErr
+			}
+			n = rest
+		} else {
+			fh.content = buf.Bytes()
+			memoryLeft -= n
+			form.File[part.FormName] = append(form.File[part.FormName], fh)
+		}
+		if limits.MaxPartSize > 0 && n > limits.MaxPartSize {
+			return nil, fmt.Errorf("multipart: part %q larger than %d bytes", part.FormName, limits.MaxPartSize)
+		}
+		fh.Size = n
+	}
+}
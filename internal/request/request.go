@@ -1,22 +1,52 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
-	"strconv"
 
 	"github.com/JA50N14/httpfromtcp/internal/headers"
 )
 
 type Request struct {
 	RequestLine RequestLine
+	Target      Target
 	Headers     headers.Headers
+	//Host and Port come from the Host header, or from the target when it has an authority
+	Host string
+	Port string
+	//RemoteAddr is the client's address as the server's connection sees it
+	RemoteAddr string
+	//ID correlates the request across logs and services; the requestid middleware sets it
+	ID   string
 	Body []byte
+	//Trailers holds the trailer section of a chunked body
+	Trailers       headers.Headers
 	bodyLengthRead int
+	contentLength  int
+	chunkRemaining int
+	maxBodySize    int
+	//sectionFields and sectionSize count the lines of the header or trailer
+	//section being parsed
+	sectionFields int
+	sectionSize   int
 	state       requestState
+	ctx            context.Context
+	//reader and beforeBody are kept while the body is still unread
+	reader     *bufio.Reader
+	beforeBody []func() error
+	//source replaces reader for bodies the transport delimits itself
+	source io.Reader
+	//decoded holds body bytes the parser produced that no Read has returned yet
+	decoded    []byte
+	decodedOff int
+	bodyErr    error
+	//bodyStarted is set once the OnReadBody hooks have run
+	bodyStarted bool
 
 }
 
@@ -32,49 +62,330 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkDataEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 const crlf = "\r\n"
-const bufferSize = 8
 
+// ReadBufferSize is the buffer size for reading requests, so the request line
+// and each header line must fit in it.
+const ReadBufferSize = 8 * 1024
+
+// ErrUnsupportedTransferCoding is returned for a Transfer-Encoding other than
+// chunked, which the server cannot decode.
+var ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+
+// ErrHeaderTooLarge is returned when the request line or a header line does
+// not fit in the read buffer, or the header or trailer section is over
+// headers.MaxFields lines or headers.MaxSectionSize bytes.
+var ErrHeaderTooLarge = errors.New("request line or header too large")
+
+// ErrBodyTooLarge is returned by ReadBody for a body over the limit set with
+// SetMaxBodySize.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// ErrVersionNotSupported is returned for well-formed request lines with an HTTP version other than 1.0 or 1.1.
+var ErrVersionNotSupported = errors.New("http version not supported")
+
+// RequestFromReader parses one request. When reader is a *bufio.Reader only the
+// bytes of this request are consumed, so it can be called again for the next
+// request on a persistent connection.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
+	req, err := RequestHeadFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = req.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// RequestHeadFromReader parses the request line and headers and leaves the
+// body in reader until ReadBody is called.
+func RequestHeadFromReader(reader io.Reader) (*Request, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(reader, ReadBufferSize)
+	}
 	req := &Request{
-		Headers: headers.NewHeaders(),
-		Body: make([]byte, 0),
-		state:   requestStateInitialized,
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+		state:    requestStateInitialized,
+		reader:   br,
+	}
+	err := req.readUntil(requestStateParsingBody)
+	if err != nil {
+		return nil, err
 	}
+	if req.state == requestStateDone {
+		req.reader = nil
+	}
+	return req, nil
+}
+
+// ReadBody reads the body into Body, first running any OnReadBody hooks. It
+// does nothing once the body has been read. Body only holds what ReadBody
+// read, so it stays empty for a body streamed through BodyReader.
+func (r *Request) ReadBody() error {
+	if r.BodyRead() {
+		return nil
+	}
+	body, err := io.ReadAll(r.BodyReader())
+	r.Body = append(r.Body, body...)
+	return err
+}
 
-	for req.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+// BodyReader streams the unread body, decoding chunked bodies, without holding
+// more than a read buffer's worth of it. The first Read runs any OnReadBody
+// hooks, and a body over the limit set with SetMaxBodySize fails with
+// ErrBodyTooLarge. Once the body has been read it returns a reader over Body.
+func (r *Request) BodyReader() io.Reader {
+	if r.BodyRead() {
+		return bytes.NewReader(r.Body)
+	}
+	return bodyReader{r}
+}
+
+// SetBodySource leaves the body unread, to be read from src until it returns
+// io.EOF. It is for transports that delimit bodies themselves, such as
+// HTTP/2.
+func (r *Request) SetBodySource(src io.Reader) {
+	r.source = src
+	r.reader = nil
+	r.state = requestStateDone
+}
+
+type bodyReader struct {
+	r *Request
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	if r.BodyRead() {
+		return 0, io.EOF
+	}
+	n, err := r.readBody(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.bodyErr = err
+	}
+	return n, err
+}
+
+func (r *Request) readBody(p []byte) (int, error) {
+	if !r.bodyStarted {
+		if r.maxBodySize > 0 && r.contentLength > r.maxBodySize {
+			//refused before any hook, so no 100 Continue asks for it
+			return 0, ErrBodyTooLarge
+		}
+		r.bodyStarted = true
+		hooks := r.beforeBody
+		r.beforeBody = nil
+		for _, hook := range hooks {
+			err := hook()
+			if err != nil {
+				return 0, err
+			}
 		}
+	}
 
-		numBytesRead, err := reader.Read(buf[readToIndex:])
+	if r.source != nil {
+		if r.bodyErr != nil {
+			return 0, r.bodyErr
+		}
+		n, err := r.source.Read(p)
+		r.bodyLengthRead += n
+		if r.maxBodySize > 0 && r.bodyLengthRead > r.maxBodySize {
+			return 0, ErrBodyTooLarge
+		}
+		if errors.Is(err, io.EOF) {
+			r.source = nil
+		}
+		return n, err
+	}
+
+	unparsed := 0
+	for r.decodedOff == len(r.decoded) {
+		r.decoded = r.decoded[:0]
+		r.decodedOff = 0
+		if r.bodyErr != nil {
+			return 0, r.bodyErr
+		}
+		if r.state == requestStateDone {
+			r.reader = nil
+			return 0, io.EOF
+		}
+		err := r.advance(requestStateDone, &unparsed)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if req.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.state, numBytesRead)
-				}
-				break
-			}
-			return nil, err
+			//what was decoded before the error is still handed out first
+			r.bodyErr = err
 		}
+	}
+	n := copy(p, r.decoded[r.decodedOff:])
+	r.decodedOff += n
+	return n, nil
+}
+
+// Context is the request's context. The server cancels it when the client
+// goes away, the server shuts down or the request times out, so handlers should
+// pass it to anything slow they call. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx, for
+// middleware that adds deadlines or values. A body that is still unread must
+// only be read through one of the copies.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
+// SetMaxBodySize makes ReadBody fail with ErrBodyTooLarge for bodies over n
+// bytes, without reading more than n of them. Zero means no limit.
+func (r *Request) SetMaxBodySize(n int) {
+	r.maxBodySize = n
+}
+
+// MaxBodySize returns the limit set with SetMaxBodySize.
+func (r *Request) MaxBodySize() int {
+	return r.maxBodySize
+}
+
+// OnReadBody registers fn to run right before an unread body is read, which
+// the server uses to send 100 Continue only once the handler wants the body.
+func (r *Request) OnReadBody(fn func() error) {
+	r.beforeBody = append(r.beforeBody, fn)
+}
+
+// BodyRead reports whether the whole body has been read.
+func (r *Request) BodyRead() bool {
+	return r.reader == nil && r.source == nil
+}
 
-		readToIndex += numBytesRead
+// ExpectContinue reports whether the client sent Expect: 100-continue and is
+// waiting for an interim response before sending the body. HTTP/1.0 clients
+// cannot expect one.
+func (r *Request) ExpectContinue() bool {
+	expect, ok := r.Headers.Get("Expect")
+	return ok && r.RequestLine.HttpVersion != "1.0" && strings.EqualFold(expect, "100-continue")
+}
 
-		numBytesParsed, err := req.parse(buf[:readToIndex])
+func (r *Request) readUntil(state requestState) error {
+	//unparsed is how many buffered bytes the parser has already seen and needs more data after
+	unparsed := 0
+	for r.state < state {
+		err := r.advance(state, &unparsed)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		copy(buf, buf[numBytesParsed:readToIndex])
-		readToIndex -= numBytesParsed
 	}
-	return req, nil
+	return nil
+}
+
+// advance parses what the reader holds past the unparsed bytes, reading more
+// first if there is nothing new.
+func (r *Request) advance(until requestState, unparsed *int) error {
+	br := r.reader
+	data, err := br.Peek(max(br.Buffered(), *unparsed+1))
+	if len(data) > *unparsed {
+		numBytesParsed, err := r.parse(data, until)
+		if err != nil {
+			return err
+		}
+		br.Discard(numBytesParsed)
+		*unparsed = len(data) - numBytesParsed
+		return nil
+	}
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return fmt.Errorf("%w: longer than %d bytes", ErrHeaderTooLarge, br.Size())
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", r.state, len(data))
+	}
+	return err
+}
+
+// KeepAlive reports whether the client wants to reuse the connection: HTTP/1.1
+// does unless it sends Connection: close, HTTP/1.0 only with Connection: keep-alive.
+func (r *Request) KeepAlive() bool {
+	conn, _ := r.Headers.Get("Connection")
+	for _, token := range strings.Split(conn, ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "close" {
+			return false
+		}
+		if token == "keep-alive" {
+			return true
+		}
+	}
+	return r.RequestLine.HttpVersion == "1.1"
+}
+
+// headersDone checks the host and works out how the body is delimited, following
+// RFC 9112 section 6.3 strictly: anything ambiguous between Transfer-Encoding and
+// Content-Length is rejected rather than guessed at, so no proxy in front of us
+// can read a different request boundary. A request without a body is complete as
+// soon as its headers are.
+func (r *Request) headersDone() error {
+	err := r.parseHost()
+	if err != nil {
+		return err
+	}
+
+	te, hasTE := r.Headers.Get("Transfer-Encoding")
+	contentLenStr, hasCL := r.Headers.Get("Content-Length")
+	if hasTE {
+		if r.RequestLine.HttpVersion == "1.0" {
+			return fmt.Errorf("transfer-encoding is not allowed in HTTP/1.0 requests")
+		}
+		if hasCL {
+			return fmt.Errorf("request has both transfer-encoding and content-length")
+		}
+		codings := strings.Split(strings.ToLower(te), ",")
+		if strings.Trim(codings[len(codings)-1], " \t") != "chunked" {
+			return fmt.Errorf("chunked must be the final transfer coding: %s", te)
+		}
+		if len(codings) > 1 {
+			//chunked is the only coding we decode, and it may only be applied once
+			if strings.Contains(strings.Join(codings[:len(codings)-1], ","), "chunked") {
+				return fmt.Errorf("chunked applied more than once: %s", te)
+			}
+			return fmt.Errorf("%w: %s", ErrUnsupportedTransferCoding, te)
+		}
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+
+	if !hasCL {
+		//assuming if no content-length header is present, there is no body
+		r.state = requestStateDone
+		return nil
+	}
+	contentLen, err := headers.ParseContentLength(contentLenStr)
+	if err != nil {
+		return fmt.Errorf("invalid content-length header: %v", err)
+	}
+	r.contentLength = contentLen
+	r.state = requestStateParsingBody
+	if contentLen == 0 {
+		r.state = requestStateDone
+	}
+	return nil
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
@@ -92,16 +403,18 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 }
 
 func requestLineFromString(str string) (*RequestLine, error) {
+	//the parts are separated by exactly one SP, any other whitespace is an error
+	if strings.ContainsAny(str, "\t\v\f\r\n") {
+		return nil, fmt.Errorf("invalid whitespace in request-line: %q", str)
+	}
 	parts := strings.Split(str, " ")
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("poorly formatted request-line: %s", str)
 	}
 
 	method := parts[0]
-	for _, char := range method {
-		if char < 'A' || char > 'Z' {
-			return nil, fmt.Errorf("invalid method in request-line: %s", method)
-		}
+	if !headers.IsToken(method) {
+		return nil, fmt.Errorf("invalid method in request-line: %s", method)
 	}
 
 	requestTarget := parts[1]
@@ -115,9 +428,12 @@ func requestLineFromString(str string) (*RequestLine, error) {
 		return nil, fmt.Errorf("invalid HTTP-version: %s", str)
 	}
 	httpVersion := versionParts[1]
-	if httpVersion != "1.1" {
+	if !validVersion(httpVersion) {
 		return nil, fmt.Errorf("invalid HTTP version: %s", httpVersion)
 	}
+	if httpVersion != "1.1" && httpVersion != "1.0" {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotSupported, httpVersion)
+	}
 
 	return &RequestLine{
 		Method:        method,
@@ -126,9 +442,14 @@ func requestLineFromString(str string) (*RequestLine, error) {
 	}, nil
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+// validVersion checks the DIGIT "." DIGIT form of HTTP-version
+func validVersion(v string) bool {
+	return len(v) == 3 && v[0] >= '0' && v[0] <= '9' && v[1] == '.' && v[2] >= '0' && v[2] <= '9'
+}
+
+func (r *Request) parse(data []byte, until requestState) (int, error) {
 	totalBytesParsed := 0
-	for r.state != requestStateDone {
+	for r.state < until {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
@@ -141,9 +462,28 @@ func (r *Request) parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
+// countSectionLine adds a parsed header or trailer line of n bytes to the
+// section's totals.
+func (r *Request) countSectionLine(n int) error {
+	if n == 0 {
+		return nil
+	}
+	r.sectionFields++
+	r.sectionSize += n
+	err := headers.CheckSection(r.sectionFields, r.sectionSize)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHeaderTooLarge, err)
+	}
+	return nil
+}
+
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case requestStateInitialized:
+		//robustness: ignore empty lines sent ahead of the request-line
+		if bytes.HasPrefix(data, []byte(crlf)) {
+			return len(crlf), nil
+		}
 		requestLine, n, err := parseRequestLine(data)
 		if err != nil {
 			// something went wrong
@@ -153,7 +493,12 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			//just need more data
 			return 0, nil
 		}
+		target, err := ParseTarget(requestLine.Method, requestLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = *requestLine
+		r.Target = target
 		r.state = requestStateParsingHeaders
 		return n, nil
 	case requestStateParsingHeaders:
@@ -164,29 +509,66 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 		if done {
 			//end of Headers
-			r.state = requestStateParsingBody
+			return n, r.headersDone()
 		}
-		return n, nil
+		return n, r.countSectionLine(n)
 	case requestStateParsingBody:
-		contentLenStr, ok := r.Headers.Get("Content-Length") 
-		if !ok {
-			//assuming if no content-length header is present, there is no body
+		//anything past content-length belongs to the next request on the connection
+		n := min(len(data), r.contentLength-r.bodyLengthRead)
+		r.decoded = append(r.decoded, data[:n]...)
+		r.bodyLengthRead += n
+		if r.bodyLengthRead == r.contentLength {
 			r.state = requestStateDone
-			return len(data), nil
 		}
-		contentLen, err := strconv.Atoi(contentLenStr)
+		return n, nil
+	case requestStateParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := headers.ParseChunkSize(data[:idx])
 		if err != nil {
-			return 0, fmt.Errorf("invalid content-length header: %s", err)
+			return 0, err
+		}
+		if r.maxBodySize > 0 && r.bodyLengthRead+size > r.maxBodySize {
+			return 0, ErrBodyTooLarge
+		}
+		if size == 0 {
+			r.state = requestStateParsingTrailers
+			r.sectionFields, r.sectionSize = 0, 0
+		} else {
+			r.chunkRemaining = size
+			r.state = requestStateParsingChunkData
+		}
+		return idx + 2, nil
+	case requestStateParsingChunkData:
+		n := min(len(data), r.chunkRemaining)
+		r.decoded = append(r.decoded, data[:n]...)
+		r.bodyLengthRead += n
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.state = requestStateParsingChunkDataEnd
 		}
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("request body larger than content-length specified in header")
+		return n, nil
+	case requestStateParsingChunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
 		}
-		if r.bodyLengthRead == contentLen {
+		if string(data[:2]) != crlf {
+			return 0, fmt.Errorf("chunk data not followed by CRLF")
+		}
+		r.state = requestStateParsingChunkSize
+		return 2, nil
+	case requestStateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
 			r.state = requestStateDone
+			return n, nil
 		}
-		return len(data), nil
+		return n, r.countSectionLine(n)
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
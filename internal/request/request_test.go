@@ -1,9 +1,13 @@
 package request
 
 import (
+	"bufio"
+	"context"
 	"io"
+	"strings"
 	"testing"
 
+	"github.com/JA50N14/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -107,8 +111,302 @@ func TestRequestLineParse(t *testing.T) {
 		numBytesPerRead: 22,
 	}
 	r, err = RequestFromReader(reader)
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrVersionNotSupported)
 	require.Nil(t, r)
+
+	//Test: Good HTTP/1.0 Request Line
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
+	//Test: Malformed version in Request Line
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.x\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrVersionNotSupported)
+}
+
+func TestRequestLineMethod(t *testing.T) {
+	//Test: Extension methods made of tchars
+	for _, method := range []string{"M-SEARCH", "PROPFIND", "BREW", "x_custom.1~"} {
+		reader := &chunkReader{
+			data:            method + " / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, method, r.RequestLine.Method)
+	}
+
+	//Test: Methods with characters outside tchar
+	for _, method := range []string{"GE(T", "G@T", "GET:", "\"GET\"", "G\x80T"} {
+		reader := &chunkReader{
+			data:            method + " / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err, method)
+	}
+}
+
+func TestRequestLineWhitespace(t *testing.T) {
+	//Test: Anything but single spaces between the parts is rejected
+	for _, line := range []string{
+		"GET  / HTTP/1.1",
+		"GET /  HTTP/1.1",
+		"GET\t/ HTTP/1.1",
+		"GET /\tHTTP/1.1",
+		" GET / HTTP/1.1",
+		"GET / HTTP/1.1 ",
+		"GET / HTTP/1.1\r",
+		"GET /\r HTTP/1.1",
+		"GET / \fHTTP/1.1",
+	} {
+		reader := &chunkReader{
+			data:            line + "\r\n\r\n",
+			numBytesPerRead: 2,
+		}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err, "%q", line)
+	}
+
+	//Test: Empty lines before the request-line are ignored
+	reader := &chunkReader{
+		data:            "\r\n\r\nGET /after HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/after", r.RequestLine.RequestTarget)
+}
+
+func TestHost(t *testing.T) {
+	cases := []struct {
+		data string
+		host string
+		port string
+	}{
+		{"GET / HTTP/1.1\r\nHost: Example.COM\r\n\r\n", "example.com", ""},
+		{"GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", "localhost", "42069"},
+		{"GET / HTTP/1.1\r\nHost: 127.0.0.1:80\r\n\r\n", "127.0.0.1", "80"},
+		{"GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n", "::1", "8080"},
+		{"GET / HTTP/1.1\r\nHost: [2001:DB8::1]\r\n\r\n", "2001:db8::1", ""},
+		{"GET / HTTP/1.1\r\nHost:\r\n\r\n", "", ""},
+		{"GET / HTTP/1.0\r\n\r\n", "", ""},
+		//absolute-form wins over the Host header
+		{"GET http://target.example:8000/x HTTP/1.1\r\nHost: other.example\r\n\r\n", "target.example", "8000"},
+		{"CONNECT proxy.example:443 HTTP/1.1\r\nHost: proxy.example:443\r\n\r\n", "proxy.example", "443"},
+	}
+	for _, tc := range cases {
+		r, err := RequestFromReader(&chunkReader{data: tc.data, numBytesPerRead: 4})
+		require.NoError(t, err, tc.data)
+		assert.Equal(t, tc.host, r.Host, tc.data)
+		assert.Equal(t, tc.port, r.Port, tc.data)
+	}
+
+	for _, data := range []string{
+		//missing
+		"GET / HTTP/1.1\r\n\r\n",
+		//more than one
+		"GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+		"GET / HTTP/1.0\r\nHost: a.example\r\nHost: a.example\r\n\r\n",
+		//malformed
+		"GET / HTTP/1.1\r\nHost: user@example.com\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com:http\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com:99999\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com:80:80\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: [::1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: [example.com]\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: exa mple.com\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com/path\r\n\r\n",
+	} {
+		_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 4})
+		require.Error(t, err, data)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	cases := []struct {
+		data      string
+		keepAlive bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n", false},
+		{"GET / HTTP/1.0\r\n\r\n", false},
+		{"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", true},
+	}
+	for _, c := range cases {
+		r, err := RequestFromReader(&chunkReader{data: c.data, numBytesPerRead: 3})
+		require.NoError(t, err)
+		assert.Equal(t, c.keepAlive, r.KeepAlive(), c.data)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	//Test: Two requests on one connection are parsed one at a time
+	reader := bufio.NewReader(&chunkReader{
+		data:            "POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhelloGET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, 0, len(r.Body))
+}
+
+func TestDeferredBody(t *testing.T) {
+	//Test: Head is parsed without touching the body
+	reader := bufio.NewReader(&chunkReader{
+		data:            "PUT /upload HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 4,
+	})
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.ExpectContinue())
+	assert.False(t, r.BodyRead())
+	assert.Equal(t, 0, len(r.Body))
+
+	//Test: Hooks run once, before the body is read
+	calls := 0
+	r.OnReadBody(func() error {
+		calls++
+		assert.False(t, r.BodyRead())
+		return nil
+	})
+	require.NoError(t, r.ReadBody())
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, 1, calls)
+	assert.True(t, r.BodyRead())
+	assert.Equal(t, "hello", string(r.Body))
+
+	//Test: A hook error stops the body from being read
+	reader = bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"))
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	r.OnReadBody(func() error { return io.ErrClosedPipe })
+	require.ErrorIs(t, r.ReadBody(), io.ErrClosedPipe)
+	assert.False(t, r.BodyRead())
+
+	//Test: A request without a body is complete after its head
+	reader = bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.BodyRead())
+
+	//Test: HTTP/1.0 clients cannot expect 100 Continue
+	reader = bufio.NewReader(strings.NewReader("POST / HTTP/1.0\r\nExpect: 100-continue\r\n\r\n"))
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.ExpectContinue())
+}
+
+func TestMaxBodySize(t *testing.T) {
+	//Test: A declared length over the limit is refused before any hook runs
+	reader := bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 6\r\n\r\nhello!"))
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	r.SetMaxBodySize(5)
+	r.OnReadBody(func() error {
+		t.Error("hook ran for a body over the limit")
+		return nil
+	})
+	require.ErrorIs(t, r.ReadBody(), ErrBodyTooLarge)
+
+	//Test: Chunked bodies are cut off at the chunk that goes over
+	reader = bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"))
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	r.SetMaxBodySize(5)
+	require.ErrorIs(t, r.ReadBody(), ErrBodyTooLarge)
+	assert.Equal(t, "abc", string(r.Body))
+
+	//Test: A body at the limit is read
+	reader = bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"))
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	r.SetMaxBodySize(5)
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "hello", string(r.Body))
+
+	//Test: Over-long header lines are told apart from other errors
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", ReadBufferSize) + "\r\n\r\n"))
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestHeaderSectionLimits(t *testing.T) {
+	//Test: Too many header fields
+	data := "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat("X: a\r\n", headers.MaxFields) + "\r\n"
+	_, err := RequestFromReader(strings.NewReader(data))
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	//Test: Too many header bytes, even in few fields
+	line := "X-Big: " + strings.Repeat("a", 4000) + "\r\n"
+	data = "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat(line, headers.MaxSectionSize/len(line)+1) + "\r\n"
+	_, err = RequestFromReader(strings.NewReader(data))
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	//Test: A section at the field limit is fine
+	data = "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat("X: a\r\n", headers.MaxFields-1) + "\r\n"
+	_, err = RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+
+	//Test: Trailers have their own section limits
+	data = "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n" + strings.Repeat("X: a\r\n", headers.MaxFields+1) + "\r\n"
+	_, err = RequestFromReader(strings.NewReader(data))
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestBodyReader(t *testing.T) {
+	//Test: A chunked body is streamed decoded, leaving the next request unread
+	reader := bufio.NewReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\nGET / HTTP/1.1\r\n",
+		numBytesPerRead: 2,
+	})
+	r, err := RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	hooks := 0
+	r.OnReadBody(func() error {
+		hooks++
+		return nil
+	})
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r.BodyReader(), buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ab", string(buf))
+	assert.False(t, r.BodyRead())
+	rest, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "cdef", string(rest))
+	assert.True(t, r.BodyRead())
+	assert.Equal(t, 1, hooks)
+	next, _ := io.ReadAll(reader)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(next))
+
+	//Test: A body source is read to EOF and held to the limit
+	r = &Request{Headers: headers.NewHeaders()}
+	r.SetBodySource(strings.NewReader("hello"))
+	assert.False(t, r.BodyRead())
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "hello", string(r.Body))
+	assert.True(t, r.BodyRead())
+
+	r = &Request{Headers: headers.NewHeaders()}
+	r.SetBodySource(strings.NewReader("hello!"))
+	r.SetMaxBodySize(5)
+	require.ErrorIs(t, r.ReadBody(), ErrBodyTooLarge)
 }
 
 // Read reads up to len(p) or numBytesPerRead bytes from the string per call
@@ -148,9 +446,9 @@ func TestHeadersParse(t *testing.T) {
 	_, err = RequestFromReader(reader)
 	require.Error(t, err)
 
-	//Test: Empty Headers
+	//Test: Empty Headers, HTTP/1.0 does not need Host
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\n\r\n",
+		data:            "GET / HTTP/1.0\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
@@ -161,13 +459,13 @@ func TestHeadersParse(t *testing.T) {
 
 	//Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nhost: localhost:42069\r\nhost: localhost:42170\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nhost: localhost:42069\r\naccept: text/html\r\naccept: */*\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:42170", r.Headers["host"])
+	assert.Equal(t, "text/html, */*", r.Headers["accept"])
 
 	//Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -257,3 +555,17 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, 0, len(r.Body))
 }
+
+func TestContext(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, context.Background(), r.Context())
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	r2 := r.WithContext(ctx)
+	assert.Equal(t, "value", r2.Context().Value(key{}))
+	assert.Equal(t, context.Background(), r.Context())
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
+	assert.Panics(t, func() { r.WithContext(nil) })
+}
@@ -0,0 +1,115 @@
+package request
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedBody(t *testing.T) {
+	//Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n, world\r\n" +
+			"000\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc123", checksum)
+
+	//Test: Coding names are case-insensitive and the next request is left alone
+	br := bufio.NewReader(&chunkReader{
+		data: "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: Chunked\r\n\r\n" +
+			"A\r\n0123456789\r\n0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1,
+	})
+	r, err = RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	r, err = RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	//Test: Identical repeated Content-Length values count once
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+// Requests that a front-end proxy and this parser could disagree on the length
+// of. Every one must be rejected.
+var smugglingPayloads = []struct {
+	name string
+	data string
+}{
+	{"CL.CL conflicting", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 7\r\n\r\nhello12"},
+	{"CL list conflicting", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 7\r\n\r\nhello12"},
+	{"CL signed plus", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello"},
+	{"CL signed minus", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -5\r\n\r\n"},
+	{"CL hex", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello"},
+	{"CL inner space", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1 0\r\n\r\nhellohello"},
+	{"CL empty", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length:\r\n\r\n"},
+	{"CL overflow", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999\r\n\r\n"},
+	{"CL.TE", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG"},
+	{"TE.CL", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n"},
+	{"TE non-final chunked", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n"},
+	{"TE chunked twice", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+	{"TE unknown coding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n"},
+	{"TE empty", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\r\n\r\n"},
+	{"TE in HTTP/1.0", "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+	{"TE space before colon", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n"},
+	{"TE obs-fold", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n"},
+	{"TE vertical tab", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\x0bchunked\r\n\r\n0\r\n\r\n"},
+	{"header obs-fold", "GET / HTTP/1.1\r\nHost: a\r\nX-Long: first\r\n\tsecond\r\n\r\n"},
+	{"whitespace before first header", "GET / HTTP/1.1\r\n Host: a\r\n\r\n"},
+	{"header without colon", "GET / HTTP/1.1\r\nHost: a\r\nX-Broken\r\n\r\n"},
+	{"empty header name", "GET / HTTP/1.1\r\nHost: a\r\n: value\r\n\r\n"},
+	{"bare LF in request line", "GET / HTTP/1.1\nHost: a\r\n\r\n"},
+	{"bare LF between headers", "GET / HTTP/1.1\r\nHost: a\nContent-Length: 5\r\n\r\nhello"},
+	{"bare CR in header value", "GET / HTTP/1.1\r\nHost: a\rContent-Length: 5\r\n\r\nhello"},
+	{"NUL in header value", "GET / HTTP/1.1\r\nHost: a\r\nX-Null: a\x00b\r\n\r\n"},
+	{"chunk size with prefix", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n"},
+	{"chunk size signed", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n"},
+	{"chunk size leading space", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n 5\r\nhello\r\n0\r\n\r\n"},
+	{"chunk size overflow", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nFFFFFFFFFFFFFFFF1\r\nhello\r\n0\r\n\r\n"},
+	{"chunk size bare LF", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n"},
+	{"chunk data too long", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n"},
+	{"chunk data bare LF", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\n0\r\n\r\n"},
+	{"chunk extension with LF", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;a\nb\r\nhello\r\n0\r\n\r\n"},
+	{"missing last chunk", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n"},
+	{"trailer obs-fold", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-T: a\r\n b\r\n\r\n"},
+}
+
+func TestSmugglingPayloads(t *testing.T) {
+	for _, tc := range smugglingPayloads {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, size := range []int{1, 3, len(tc.data)} {
+				_, err := RequestFromReader(&chunkReader{data: tc.data, numBytesPerRead: size})
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedTransferCoding(t *testing.T) {
+	data := "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n"
+	_, err := RequestFromReader(strings.NewReader(data))
+	require.ErrorIs(t, err, ErrUnsupportedTransferCoding)
+}
@@ -0,0 +1,251 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TargetForm int
+
+const (
+	TargetFormOrigin TargetForm = iota
+	TargetFormAbsolute
+	TargetFormAuthority
+	TargetFormAsterisk
+)
+
+// Target is the parsed request-target. Path is percent-decoded with dot-segments
+// removed; RawPath is the same path still percent-encoded, for proxying.
+type Target struct {
+	Form      TargetForm
+	Scheme    string
+	Authority string
+	Path      string
+	RawPath   string
+	RawQuery  string
+	Query     Query
+	Fragment  string
+}
+
+// Query holds decoded query parameters, keeping every value of repeated keys in order.
+type Query map[string][]string
+
+func (q Query) Get(key string) string {
+	if vals := q[key]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (q Query) Values(key string) []string {
+	return q[key]
+}
+
+// ParseTarget splits a request-target into its components. Which forms are
+// allowed depends on the method: authority-form is only for CONNECT and
+// asterisk-form only for OPTIONS.
+func ParseTarget(method, target string) (Target, error) {
+	t := Target{}
+	if target == "" {
+		return t, fmt.Errorf("empty request-target")
+	}
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] >= 0x7f {
+			return t, fmt.Errorf("invalid character in request-target: %q", target)
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		if strings.ContainsAny(target, "/?#") || !strings.Contains(target, ":") {
+			return t, fmt.Errorf("CONNECT requires authority-form target: %s", target)
+		}
+		t.Form = TargetFormAuthority
+		t.Authority = target
+		return t, nil
+	case target == "*":
+		if method != "OPTIONS" {
+			return t, fmt.Errorf("asterisk-form target is only allowed for OPTIONS")
+		}
+		t.Form = TargetFormAsterisk
+		t.Path = "*"
+		t.RawPath = "*"
+		t.Query = Query{}
+		return t, nil
+	case strings.HasPrefix(target, "/"):
+		t.Form = TargetFormOrigin
+	default:
+		scheme, rest, ok := strings.Cut(target, "://")
+		if !ok || !validScheme(scheme) {
+			return t, fmt.Errorf("invalid request-target: %s", target)
+		}
+		t.Form = TargetFormAbsolute
+		t.Scheme = strings.ToLower(scheme)
+		end := strings.IndexAny(rest, "/?#")
+		if end == -1 {
+			end = len(rest)
+		}
+		t.Authority = rest[:end]
+		if t.Authority == "" {
+			return t, fmt.Errorf("missing authority in request-target: %s", target)
+		}
+		target = rest[end:]
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+	}
+
+	target, t.Fragment, _ = strings.Cut(target, "#")
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+
+	normalised, err := decodeUnreserved(rawPath)
+	if err != nil {
+		return t, err
+	}
+	t.RawPath = removeDotSegments(normalised)
+	t.Path, err = unescape(t.RawPath, false)
+	if err != nil {
+		return t, err
+	}
+	t.RawQuery = rawQuery
+	t.Query, err = ParseQuery(rawQuery)
+	if err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// ParseQuery decodes application/x-www-form-urlencoded data such as a query string.
+func ParseQuery(raw string) (Query, error) {
+	q := Query{}
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawVal, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		val, err := unescape(rawVal, true)
+		if err != nil {
+			return nil, err
+		}
+		q[key] = append(q[key], val)
+	}
+	return q, nil
+}
+
+func validScheme(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if !isAlpha(c) && !(c >= '0' && c <= '9') && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isUnreserved(c byte) bool {
+	return isAlpha(c) || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// percentByte decodes the %XX escape at s[i].
+func percentByte(s string, i int) (byte, error) {
+	if i+2 >= len(s) {
+		return 0, fmt.Errorf("truncated percent-encoding in %q", s)
+	}
+	hi, ok1 := unhex(s[i+1])
+	lo, ok2 := unhex(s[i+2])
+	if !ok1 || !ok2 {
+		return 0, fmt.Errorf("invalid percent-encoding in %q", s)
+	}
+	return hi<<4 | lo, nil
+}
+
+// decodeUnreserved decodes escapes of unreserved characters (RFC 3986 6.2.2.2)
+// so that "%2e%2e" is treated as ".." by dot-segment removal.
+func decodeUnreserved(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		c, err := percentByte(s, i)
+		if err != nil {
+			return "", err
+		}
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func unescape(s string, plusIsSpace bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			c, err := percentByte(s, i)
+			if err != nil {
+				return "", err
+			}
+			b.WriteByte(c)
+			i += 2
+		case s[i] == '+' && plusIsSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// removeDotSegments implements RFC 3986 5.2.4 for an absolute path.
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")[1:]
+	out := []string{}
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
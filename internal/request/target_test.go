@@ -0,0 +1,77 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	//Test: Origin-form with query and fragment
+	target, err := ParseTarget("GET", "/search/caf%C3%A9?q=a+b&tag=x&tag=y%26z#top")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormOrigin, target.Form)
+	assert.Equal(t, "/search/café", target.Path)
+	assert.Equal(t, "/search/caf%C3%A9", target.RawPath)
+	assert.Equal(t, "q=a+b&tag=x&tag=y%26z", target.RawQuery)
+	assert.Equal(t, "a b", target.Query.Get("q"))
+	assert.Equal(t, []string{"x", "y&z"}, target.Query.Values("tag"))
+	assert.Equal(t, "top", target.Fragment)
+
+	//Test: Dot-segments are removed, including encoded ones
+	target, err = ParseTarget("GET", "/a/b/../c/./d/%2e%2e/e")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/c/e", target.Path)
+
+	//Test: Dot-segments cannot climb above the root
+	target, err = ParseTarget("GET", "/../../etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/passwd", target.Path)
+
+	//Test: Encoded slash stays encoded in the raw path
+	target, err = ParseTarget("GET", "/files/a%2fb")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a/b", target.Path)
+	assert.Equal(t, "/files/a%2Fb", target.RawPath)
+
+	//Test: Absolute-form
+	target, err = ParseTarget("GET", "HTTP://example.com:8080/index.html?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAbsolute, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Authority)
+	assert.Equal(t, "/index.html", target.Path)
+	assert.Equal(t, "1", target.Query.Get("x"))
+
+	//Test: Absolute-form without a path
+	target, err = ParseTarget("GET", "http://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "/", target.Path)
+
+	//Test: Authority-form for CONNECT
+	target, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAuthority, target.Form)
+	assert.Equal(t, "example.com:443", target.Authority)
+
+	//Test: Asterisk-form for OPTIONS
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAsterisk, target.Form)
+
+	//Test: Invalid targets
+	for _, c := range []struct{ method, target string }{
+		{"GET", "*"},
+		{"CONNECT", "/path"},
+		{"GET", "example.com"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "/truncated%4"},
+		{"GET", "/q?x=%"},
+		{"GET", "/ctl\x01char"},
+		{"GET", "http:///nohost"},
+	} {
+		_, err = ParseTarget(c.method, c.target)
+		require.Error(t, err, c.target)
+	}
+}
@@ -0,0 +1,79 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/JA50N14/httpfromtcp/internal/server"
+)
+
+// Header carries the request ID in both directions.
+const Header = "X-Request-ID"
+
+// MaxLength is the longest incoming ID that is kept. It fits UUIDs, ULIDs and
+// the hex or base64 IDs other proxies generate, but not a pasted payload.
+const MaxLength = 128
+
+// Valid reports whether id, received from a client or a proxy in front of us,
+// is safe to use: 1 to MaxLength letters, digits, "-", "_", "." or ":". Other
+// characters could forge log lines or break formats that quote the ID.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		alnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !alnum && c != '-' && c != '_' && c != '.' && c != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// New returns a UUIDv7: a millisecond timestamp followed by random bits, so
+// IDs sort by the time they were made.
+func New() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(uuid[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(uuid[2:6], uint32(ms))
+	uuid[6] = 0x70 | uuid[6]&0x0f
+	uuid[8] = 0x80 | uuid[8]&0x3f
+
+	var b [36]byte
+	hex.Encode(b[0:8], uuid[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], uuid[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], uuid[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], uuid[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], uuid[10:])
+	return string(b[:])
+}
+
+// Middleware sets req.ID to the X-Request-ID the client sent, or to a new ID
+// if it sent none or an invalid one, and echoes it in the response. It
+// changes the request in place, so it should come first in the chain for
+// the middleware after it to see the ID.
+func Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id, ok := req.Headers.Get(Header)
+		if !ok || !Valid(id) {
+			id = New()
+		}
+		req.ID = id
+		w.OnWriteHeaders(func(h headers.Headers) {
+			h.Override(Header, id)
+		})
+		next(w, req)
+	}
+}
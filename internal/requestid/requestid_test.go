@@ -0,0 +1,75 @@
+package requestid
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var uuidv7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNew(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id := New()
+	assert.Regexp(t, uuidv7, id)
+	assert.True(t, Valid(id))
+
+	//Test: The first 48 bits are the creation time in milliseconds
+	var ms int64
+	for _, c := range strings.ReplaceAll(id[:13], "-", "") {
+		ms = ms<<4 | int64(strings.IndexRune("0123456789abcdef", c))
+	}
+	assert.GreaterOrEqual(t, ms, before)
+	assert.LessOrEqual(t, ms, time.Now().UnixMilli())
+
+	assert.NotEqual(t, id, New())
+}
+
+func TestValid(t *testing.T) {
+	for _, id := range []string{"abc123", "01ARZ3NDEKTSV4RRFFQ69G5FAV", "0191c3f2-7b1e-7c3a-9f2d-4b8e6a1c2d3e", "req_1.2:3"} {
+		assert.True(t, Valid(id), id)
+	}
+	for _, id := range []string{"", "a b", "a\nb", `a"b`, "a,b", "é", strings.Repeat("a", MaxLength+1)} {
+		assert.False(t, Valid(id), id)
+	}
+}
+
+func serve(t *testing.T, raw string) (string, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	var seen string
+	Middleware(func(w *response.Writer, req *request.Request) {
+		seen = req.ID
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})(response.NewWriter(&out), req)
+	assert.Equal(t, seen, req.ID)
+	return seen, out.String()
+}
+
+func TestMiddleware(t *testing.T) {
+	//Test: A valid incoming ID is kept and echoed
+	id, out := serve(t, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", id)
+	assert.Contains(t, strings.ToLower(out), "x-request-id: abc-123\r\n")
+
+	//Test: Without one, or with an invalid one, a new ID is generated
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: " + strings.Repeat("a", MaxLength+1) + "\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: <script>\r\n\r\n",
+	} {
+		id, out := serve(t, raw)
+		assert.Regexp(t, uuidv7, id)
+		assert.Contains(t, strings.ToLower(out), "x-request-id: "+id+"\r\n")
+	}
+}
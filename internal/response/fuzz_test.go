@@ -0,0 +1,126 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fuzzSeeds = []string{
+	"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Trailer: t\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\nhello\r\n0\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nFFFFFFFFFFFFFFFF1\r\n",
+	"HTTP/1.0 200 OK\r\n\r\nread until close",
+	"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+	"HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+	"HTTP/1.1 999\r\n\r\n",
+	"HTTP/1.1 200 OK\r\n",
+	"",
+}
+
+// FuzzResponseFromReader checks the response parser never panics, never
+// produces more body than it was given, and reaches the same result however
+// the bytes are split across reads or whether the body is streamed.
+func FuzzResponseFromReader(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, uint8(1), false)
+		f.Add(seed, uint8(7), true)
+	}
+
+	f.Fuzz(func(t *testing.T, data string, numBytesPerRead uint8, head bool) {
+		if numBytesPerRead == 0 {
+			numBytesPerRead = 1
+		}
+		method := "GET"
+		if head {
+			method = "HEAD"
+		}
+		whole, wholeErr := ResponseFromReader(strings.NewReader(data), method)
+		split, splitErr := ResponseFromReader(&chunkReader{data: data, numBytesPerRead: int(numBytesPerRead)}, method)
+		require.Equal(t, wholeErr == nil, splitErr == nil, "whole: %v, split: %v", wholeErr, splitErr)
+		if wholeErr != nil {
+			return
+		}
+		assert.LessOrEqual(t, len(whole.Body), len(data))
+		assert.Equal(t, whole.StatusLine, split.StatusLine)
+		assert.Equal(t, whole.Interim, split.Interim)
+		assert.Equal(t, whole.Headers, split.Headers)
+		assert.Equal(t, whole.Body, split.Body)
+		assert.Equal(t, whole.Trailers, split.Trailers)
+
+		br := bufio.NewReader(&chunkReader{data: data, numBytesPerRead: int(numBytesPerRead)})
+		streamed, err := ResponseHeadFromReader(br)
+		require.NoError(t, err)
+		body, err := streamed.NewBodyReader(br, method)
+		require.NoError(t, err)
+		got, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, string(whole.Body), string(got))
+	})
+}
+
+// FuzzChunkedRoundTrip writes body through the chunked writer, split into
+// chunks of chunkSize bytes, and checks that both the response parser and the
+// request parser decode the same body and trailer back, however the encoded
+// bytes are split across reads.
+func FuzzChunkedRoundTrip(f *testing.F) {
+	f.Add([]byte("hello, world"), uint8(5), uint8(3), "abc123")
+	f.Add([]byte(""), uint8(1), uint8(1), "")
+	f.Add([]byte("0\r\n\r\n"), uint8(2), uint8(7), "x")
+	f.Add(bytes.Repeat([]byte{0xff, '\r', '\n'}, 100), uint8(255), uint8(16), "deadbeef")
+
+	f.Fuzz(func(t *testing.T, body []byte, chunkSize, numBytesPerRead uint8, checksum string) {
+		if chunkSize == 0 {
+			chunkSize = 1
+		}
+		if numBytesPerRead == 0 {
+			numBytesPerRead = 1
+		}
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		require.NoError(t, w.WriteHeaders(h))
+		for p := body; len(p) > 0; p = p[min(len(p), int(chunkSize)):] {
+			_, err := w.WriteChunkedBody(p[:min(len(p), int(chunkSize))])
+			require.NoError(t, err)
+		}
+		_, err := w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", checksum)
+		err = w.WriteTrailers(trailers)
+		if !headers.IsToken(checksum) {
+			//only token values are sure to come back unchanged
+			return
+		}
+		require.NoError(t, err)
+
+		resp, err := ResponseFromReader(&chunkReader{data: buf.String(), numBytesPerRead: int(numBytesPerRead)}, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, body, resp.Body)
+		got, _ := resp.Trailers.Get("X-Checksum")
+		assert.Equal(t, checksum, got)
+
+		//the same chunked body sent as a request
+		_, chunked, _ := strings.Cut(buf.String(), "\r\n\r\n")
+		raw := fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n%s", chunked)
+		req, err := request.RequestFromReader(&chunkReader{data: raw, numBytesPerRead: int(numBytesPerRead)})
+		require.NoError(t, err)
+		assert.Equal(t, body, req.Body)
+		got, _ = req.Trailers.Get("X-Checksum")
+		assert.Equal(t, checksum, got)
+	})
+}
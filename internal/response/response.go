@@ -0,0 +1,368 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+)
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	Trailers   headers.Headers
+	//Interim holds any 1xx responses that came before the final one
+	Interim []InterimResponse
+
+	method         string
+	headOnly       bool
+	state          responseState
+	bodyLength     int
+	bodyLengthRead int
+	chunkRemaining int
+	//sectionFields and sectionSize count the lines of the header or trailer
+	//section being parsed
+	sectionFields int
+	sectionSize   int
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type InterimResponse struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+}
+
+type responseState int
+
+const (
+	responseStateStatusLine responseState = iota
+	responseStateHeaders
+	responseStateBody
+	responseStateChunkSize
+	responseStateChunkData
+	responseStateChunkDataEnd
+	responseStateTrailers
+	responseStateCloseDelimited
+	responseStateDone
+)
+
+const crlf = "\r\n"
+
+// readBufferSize bounds the read buffer, so the status line and each header line must fit in it.
+const readBufferSize = 8 * 1024
+
+// ResponseFromReader parses a complete response to a request made with method,
+// which matters because responses to HEAD never have a body.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	return parseResponse(reader, method, false)
+}
+
+// ResponseHeadFromReader parses the status line and headers of the final
+// response, skipping 1xx responses, and leaves the body unread in reader.
+func ResponseHeadFromReader(reader *bufio.Reader) (*Response, error) {
+	return parseResponse(reader, "", true)
+}
+
+func parseResponse(reader io.Reader, method string, headOnly bool) (*Response, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(reader, readBufferSize)
+	}
+	resp := &Response{
+		Headers:    headers.NewHeaders(),
+		Body:       make([]byte, 0),
+		Trailers:   headers.NewHeaders(),
+		method:     method,
+		headOnly:   headOnly,
+		state:      responseStateStatusLine,
+		bodyLength: -1,
+	}
+
+	unparsed := 0
+	for resp.state != responseStateDone {
+		err := resp.advance(br, &unparsed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// advance parses what br holds past the unparsed bytes, reading more first if
+// there is nothing new. unparsed carries over the bytes of an incomplete line.
+func (r *Response) advance(br *bufio.Reader, unparsed *int) error {
+	data, err := br.Peek(max(br.Buffered(), *unparsed+1))
+	if len(data) > *unparsed {
+		n, err := r.parse(data)
+		if err != nil {
+			return err
+		}
+		br.Discard(n)
+		*unparsed = len(data) - n
+		return nil
+	}
+	if errors.Is(err, io.EOF) && r.state == responseStateCloseDelimited {
+		//the server closing the connection ends this kind of body
+		r.state = responseStateDone
+		return nil
+	}
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return fmt.Errorf("response line or header longer than %d bytes", br.Size())
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("incomplete response, in state: %d, read n bytes on EOF: %d: %w", r.state, len(data), io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// BodyReader streams the body of a response whose head was read with
+// ResponseHeadFromReader, decoding it with the same parser that
+// ResponseFromReader uses. Only a buffer's worth of the body is held at a
+// time.
+type BodyReader struct {
+	resp     *Response
+	reader   *bufio.Reader
+	unparsed int
+	//off is how much of resp.Body, the decoded bytes not yet returned, Read has handed out
+	off int
+	err error
+}
+
+// NewBodyReader starts reading r's body from reader, which must be the reader
+// its head came from. method is that of the request, since responses to HEAD
+// have no body. For a chunked body r.Trailers is filled in once Read returns
+// io.EOF.
+func (r *Response) NewBodyReader(reader *bufio.Reader, method string) (*BodyReader, error) {
+	r.method = method
+	r.headOnly = false
+	r.Body = r.Body[:0]
+	err := r.headersDone()
+	if err != nil {
+		return nil, err
+	}
+	return &BodyReader{resp: r, reader: reader}, nil
+}
+
+// CloseDelimited reports whether the body runs until the server closes the
+// connection, which then can't be reused.
+func (b *BodyReader) CloseDelimited() bool {
+	return b.resp.state == responseStateCloseDelimited
+}
+
+func (b *BodyReader) Read(p []byte) (int, error) {
+	for b.off == len(b.resp.Body) {
+		b.resp.Body = b.resp.Body[:0]
+		b.off = 0
+		if b.resp.state == responseStateDone {
+			return 0, io.EOF
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.err = b.resp.advance(b.reader, &b.unparsed)
+	}
+	n := copy(p, b.resp.Body[b.off:])
+	b.off += n
+	return n, nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+	for r.state != responseStateDone {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += n
+		if n == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+// countSectionLine adds a parsed header or trailer line of n bytes to the
+// section's totals.
+func (r *Response) countSectionLine(n int) error {
+	if n == 0 {
+		return nil
+	}
+	r.sectionFields++
+	r.sectionSize += n
+	return headers.CheckSection(r.sectionFields, r.sectionSize)
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case responseStateStatusLine:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		statusLine, err := statusLineFromString(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		r.StatusLine = *statusLine
+		r.state = responseStateHeaders
+		return idx + 2, nil
+	case responseStateHeaders:
+		n, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			return n, r.headersDone()
+		}
+		return n, r.countSectionLine(n)
+	case responseStateBody:
+		n := min(len(data), r.bodyLength-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
+		if r.bodyLengthRead == r.bodyLength {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := headers.ParseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.state = responseStateTrailers
+			r.sectionFields, r.sectionSize = 0, 0
+		} else {
+			r.chunkRemaining = size
+			r.state = responseStateChunkData
+		}
+		return idx + 2, nil
+	case responseStateChunkData:
+		n := min(len(data), r.chunkRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.state = responseStateChunkDataEnd
+		}
+		return n, nil
+	case responseStateChunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if string(data[:2]) != crlf {
+			return 0, fmt.Errorf("chunk data longer than chunk size")
+		}
+		r.state = responseStateChunkSize
+		return 2, nil
+	case responseStateTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = responseStateDone
+			return n, nil
+		}
+		return n, r.countSectionLine(n)
+	case responseStateCloseDelimited:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+	case responseStateDone:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	default:
+		return 0, fmt.Errorf("error: unknown state")
+	}
+}
+
+// headersDone picks how the body is delimited, following RFC 9112 section 6.3.
+func (r *Response) headersDone() error {
+	code := r.StatusLine.StatusCode
+	if code < 200 && code != 101 {
+		//interim response, the final one follows
+		r.Interim = append(r.Interim, InterimResponse{StatusLine: r.StatusLine, Headers: r.Headers})
+		r.Headers = headers.NewHeaders()
+		r.state = responseStateStatusLine
+		//the section totals carry on, so endless 1xx responses hit them too
+		r.sectionFields++
+		return headers.CheckSection(r.sectionFields, r.sectionSize)
+	}
+	if r.headOnly || r.method == "HEAD" || code < 200 || code == 204 || code == 304 {
+		r.state = responseStateDone
+		return nil
+	}
+
+	if te, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		codings := strings.Split(strings.ToLower(te), ",")
+		if strings.TrimSpace(codings[len(codings)-1]) == "chunked" {
+			r.state = responseStateChunkSize
+		} else {
+			r.state = responseStateCloseDelimited
+		}
+		return nil
+	}
+
+	if cl, ok := r.Headers.Get("Content-Length"); ok {
+		n, err := headers.ParseContentLength(cl)
+		if err != nil {
+			return fmt.Errorf("invalid content-length header: %v", err)
+		}
+		r.bodyLength = n
+		r.state = responseStateBody
+		if n == 0 {
+			r.state = responseStateDone
+		}
+		return nil
+	}
+	r.state = responseStateCloseDelimited
+	return nil
+}
+
+func statusLineFromString(str string) (*StatusLine, error) {
+	version, rest, ok := strings.Cut(str, " ")
+	if !ok {
+		return nil, fmt.Errorf("poorly formatted status-line: %s", str)
+	}
+	httpVersion, ok := strings.CutPrefix(version, "HTTP/")
+	if !ok || len(httpVersion) != 3 || httpVersion[1] != '.' || !isDigit(httpVersion[0]) || !isDigit(httpVersion[2]) {
+		return nil, fmt.Errorf("invalid HTTP-version in status-line: %s", str)
+	}
+	if httpVersion[0] != '1' {
+		return nil, fmt.Errorf("unsupported HTTP version: %s", httpVersion)
+	}
+
+	//the reason phrase may be empty, and some servers leave out the space before it
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 || !isDigit(code[0]) || !isDigit(code[1]) || !isDigit(code[2]) || code[0] == '0' {
+		return nil, fmt.Errorf("invalid status code in status-line: %s", str)
+	}
+	for i := 0; i < len(reason); i++ {
+		c := reason[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return nil, fmt.Errorf("invalid character in reason phrase: %q", reason)
+		}
+	}
+	statusCode, _ := strconv.Atoi(code)
+	return &StatusLine{
+		HttpVersion:  httpVersion,
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
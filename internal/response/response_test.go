@@ -0,0 +1,346 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestStatusLineParse(t *testing.T) {
+	//Test: Good status line
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+
+	//Test: Reason phrase with spaces and obs-text
+	reader = &chunkReader{
+		data:            "HTTP/1.0 404 Not  Found \xe2\x80\x94 sorry\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCode(404), r.StatusLine.StatusCode)
+	assert.Equal(t, "Not  Found \xe2\x80\x94 sorry", r.StatusLine.ReasonPhrase)
+
+	//Test: Empty reason phrase, with and without the trailing space
+	for _, line := range []string{"HTTP/1.1 500 \r\n", "HTTP/1.1 500\r\n"} {
+		reader = &chunkReader{
+			data:            line + "Content-Length: 0\r\n\r\n",
+			numBytesPerRead: 2,
+		}
+		r, err = ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, StatusCodeInternalServerError, r.StatusLine.StatusCode)
+		assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+	}
+
+	//Test: Invalid status code
+	reader = &chunkReader{
+		data:            "HTTP/1.1 20 OK\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	//Test: Invalid version
+	reader = &chunkReader{
+		data:            "HTTP/2.0 200 OK\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	//Test: Control character in reason phrase
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 O\x00K\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+}
+
+func TestInterimResponses(t *testing.T) {
+	//Test: 100 Continue and 103 Early Hints before the final response
+	reader := &chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 5,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(r.Body))
+	require.Len(t, r.Interim, 2)
+	assert.Equal(t, StatusCode(100), r.Interim[0].StatusLine.StatusCode)
+	assert.Equal(t, StatusCode(103), r.Interim[1].StatusLine.StatusCode)
+	link, _ := r.Interim[1].Headers.Get("Link")
+	assert.Equal(t, "</style.css>; rel=preload", link)
+	_, ok := r.Headers.Get("Link")
+	assert.False(t, ok)
+
+	//Test: 101 Switching Protocols is final and has no body
+	reader = &chunkReader{
+		data:            "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(101), r.StatusLine.StatusCode)
+	assert.Empty(t, r.Interim)
+	assert.Empty(t, r.Body)
+
+	//Test: Endless interim responses are cut off
+	_, err = ResponseFromReader(strings.NewReader(strings.Repeat("HTTP/1.1 100 Continue\r\n\r\n", headers.MaxFields+1)), "GET")
+	require.ErrorIs(t, err, headers.ErrSectionTooLarge)
+}
+
+func TestHeaderSectionLimits(t *testing.T) {
+	//Test: Too many header fields
+	data := "HTTP/1.1 200 OK\r\n" + strings.Repeat("X: a\r\n", headers.MaxFields+1) + "Content-Length: 0\r\n\r\n"
+	_, err := ResponseFromReader(strings.NewReader(data), "GET")
+	require.ErrorIs(t, err, headers.ErrSectionTooLarge)
+
+	//Test: Too many trailer fields
+	data = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n" + strings.Repeat("X: a\r\n", headers.MaxFields+1) + "\r\n"
+	_, err = ResponseFromReader(strings.NewReader(data), "GET")
+	require.ErrorIs(t, err, headers.ErrSectionTooLarge)
+}
+
+func TestResponseBody(t *testing.T) {
+	//Test: Content-Length body
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	//Test: Body shorter than Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	//Test: Invalid Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	//Test: Responses to HEAD, 204 and 304 have no body whatever the headers say
+	cases := []struct {
+		method string
+		data   string
+	}{
+		{"HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"},
+		{"GET", "HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n"},
+		{"GET", "HTTP/1.1 304 Not Modified\r\nTransfer-Encoding: chunked\r\n\r\n"},
+	}
+	for _, tc := range cases {
+		reader = &chunkReader{
+			data:            tc.data + "HTTP/1.1 200 OK\r\n",
+			numBytesPerRead: 4,
+		}
+		br := bufio.NewReader(reader)
+		r, err = ResponseFromReader(br, tc.method)
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		//the next response is left unread
+		next, err := br.Peek(8)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1", string(next))
+	}
+
+	//Test: Close-delimited body
+	reader = &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the connection closes",
+		numBytesPerRead: 7,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "until the connection closes", string(r.Body))
+
+	//Test: Transfer-Encoding without chunked last is close-delimited
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\nraw bytes",
+		numBytesPerRead: 7,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "raw bytes", string(r.Body))
+}
+
+func TestChunkedResponseBody(t *testing.T) {
+	//Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n, world\r\n" +
+			"A\r\n from TCP!\r\n" +
+			"0\r\nX-Checksum: abc123\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world from TCP!", string(r.Body))
+	checksum, ok := r.Trailers.Get("X-Checksum")
+	require.True(t, ok)
+	assert.Equal(t, "abc123", checksum)
+
+	//Test: Chunked body with no trailers, leaving the next response unread
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\nHTTP/1.1 200 OK\r\n",
+		numBytesPerRead: 1,
+	}
+	br := bufio.NewReader(reader)
+	r, err = ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.Empty(t, r.Trailers)
+	rest, _ := io.ReadAll(br)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(rest))
+
+	//Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabcd\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	//Test: Invalid chunk size
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	//Test: Signed and overflowing chunk sizes are errors, not negative lengths
+	for _, size := range []string{"-1", "+5", "-0", "0x5", "FFFFFFFFFFFFFFFF1"} {
+		reader = &chunkReader{
+			data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + size + "\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err = ResponseFromReader(reader, "GET")
+		require.Error(t, err, size)
+	}
+
+	//Test: Missing last chunk
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+}
+
+func TestResponseHeadFromReader(t *testing.T) {
+	//Test: Body is left in the reader
+	br := bufio.NewReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nbody"))
+	r, err := ResponseHeadFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+	rest, _ := io.ReadAll(br)
+	assert.Equal(t, "body", string(rest))
+}
+
+func TestBodyReader(t *testing.T) {
+	//Test: Chunked body streamed in small reads, trailers at EOF and the next response left unread
+	br := bufio.NewReaderSize(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n7;a=b\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+		numBytesPerRead: 2,
+	}, 64)
+	r, err := ResponseHeadFromReader(br)
+	require.NoError(t, err)
+	body, err := r.NewBodyReader(br, "GET")
+	require.NoError(t, err)
+	assert.False(t, body.CloseDelimited())
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(data))
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+	rest, _ := io.ReadAll(br)
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", string(rest))
+
+	//Test: Content-Length body cut short
+	br = bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"))
+	r, err = ResponseHeadFromReader(br)
+	require.NoError(t, err)
+	body, err = r.NewBodyReader(br, "GET")
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "short", string(data))
+
+	//Test: Close-delimited body, and no body for HEAD
+	br = bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nuntil the end"))
+	r, err = ResponseHeadFromReader(br)
+	require.NoError(t, err)
+	body, err = r.NewBodyReader(br, "GET")
+	require.NoError(t, err)
+	assert.True(t, body.CloseDelimited())
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(data))
+
+	br = bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\n"))
+	r, err = ResponseHeadFromReader(br)
+	require.NoError(t, err)
+	body, err = r.NewBodyReader(br, "HEAD")
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	//Test: A signed chunk size is an error
+	br = bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\nx\r\n0\r\n\r\n"))
+	r, err = ResponseHeadFromReader(br)
+	require.NoError(t, err)
+	body, err = r.NewBodyReader(br, "GET")
+	require.NoError(t, err)
+	_, err = io.ReadAll(body)
+	assert.Error(t, err)
+}
@@ -7,20 +7,71 @@ import (
 type StatusCode int
 
 const (
+	StatusCodeContinue StatusCode = 100
+	StatusCodeEarlyHints StatusCode = 103
 	StatusCodeSuccess StatusCode = 200
+	StatusCodeNoContent StatusCode = 204
+	StatusCodePermanentRedirect StatusCode = 308
 	StatusCodeBadRequest StatusCode = 400
+	StatusCodeNotFound StatusCode = 404
+	StatusCodeMethodNotAllowed StatusCode = 405
+	StatusCodeNotAcceptable StatusCode = 406
+	StatusCodeRequestTimeout StatusCode = 408
+	StatusCodeContentTooLarge StatusCode = 413
+	StatusCodeUnsupportedMediaType StatusCode = 415
+	StatusCodeExpectationFailed StatusCode = 417
+	StatusCodeTooManyRequests StatusCode = 429
+	StatusCodeRequestHeaderFieldsTooLarge StatusCode = 431
 	StatusCodeInternalServerError StatusCode = 500
+	StatusCodeNotImplemented StatusCode = 501
+	StatusCodeHTTPVersionNotSupported StatusCode = 505
 )
 
-func getStatusLine(statusCode StatusCode) []byte {
+func getStatusLine(version string, statusCode StatusCode) []byte {
+	return []byte(fmt.Sprintf("HTTP/%s %d %s\r\n", version, statusCode, StatusText(statusCode)))
+}
+
+// StatusText returns the reason phrase for statusCode, or "" if it is not one
+// of ours.
+func StatusText(statusCode StatusCode) string {
 	var reasonPhrase string
 	switch statusCode {
+	case StatusCodeContinue:
+		reasonPhrase = "Continue"
+	case StatusCodeEarlyHints:
+		reasonPhrase = "Early Hints"
 	case StatusCodeSuccess:
 		reasonPhrase = "OK"
+	case StatusCodeNoContent:
+		reasonPhrase = "No Content"
+	case StatusCodePermanentRedirect:
+		reasonPhrase = "Permanent Redirect"
 	case StatusCodeBadRequest:
 		reasonPhrase = "Bad Request"
+	case StatusCodeNotFound:
+		reasonPhrase = "Not Found"
+	case StatusCodeMethodNotAllowed:
+		reasonPhrase = "Method Not Allowed"
+	case StatusCodeNotAcceptable:
+		reasonPhrase = "Not Acceptable"
+	case StatusCodeRequestTimeout:
+		reasonPhrase = "Request Timeout"
+	case StatusCodeContentTooLarge:
+		reasonPhrase = "Content Too Large"
+	case StatusCodeUnsupportedMediaType:
+		reasonPhrase = "Unsupported Media Type"
+	case StatusCodeExpectationFailed:
+		reasonPhrase = "Expectation Failed"
+	case StatusCodeTooManyRequests:
+		reasonPhrase = "Too Many Requests"
+	case StatusCodeRequestHeaderFieldsTooLarge:
+		reasonPhrase = "Request Header Fields Too Large"
 	case StatusCodeInternalServerError:
 		reasonPhrase = "Internal Server Error"
+	case StatusCodeNotImplemented:
+		reasonPhrase = "Not Implemented"
+	case StatusCodeHTTPVersionNotSupported:
+		reasonPhrase = "HTTP Version Not Supported"
 	}
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase)) 
+	return reasonPhrase
 }
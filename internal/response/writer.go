@@ -1,12 +1,22 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"strconv"
+	"strings"
 
+	"github.com/JA50N14/httpfromtcp/internal/cookie"
 	"github.com/JA50N14/httpfromtcp/internal/headers"
 )
 
+// ErrAbortHandler can be panicked with by a handler to abort its response:
+// the server drops the connection (or resets the HTTP/2 stream) without
+// logging a stack trace.
+var ErrAbortHandler = errors.New("response: abort handler")
+
 type writerState int
 
 const (
@@ -19,12 +29,73 @@ const (
 type Writer struct {
 	writerState writerState
 	writer      io.Writer
+	transport   Transport
+	statusCode  StatusCode
+
+	version   string
+	keepAlive bool
+	//head is set for responses to HEAD, which carry headers but no body
+	head bool
+	//unchunked is set when chunked writes go to an HTTP/1.0 client as a close-delimited body
+	unchunked     bool
+	chunkedOpen   bool
+	contentLength int
+	bodyWritten   int
+	//sent counts the body bytes that actually went to the client
+	sent        int
+	cookies     []*cookie.Cookie
+	headerHooks []func(h headers.Headers)
+}
+
+// Transport carries a response over a framed protocol such as HTTP/2 instead of
+// writing HTTP/1.1 text to the connection.
+type Transport interface {
+	WriteHeaders(statusCode StatusCode, h headers.Headers) error
+	WriteInterim(statusCode StatusCode, h headers.Headers) error
+	WriteData(p []byte) error
+	WriteTrailers(h headers.Headers) error
 }
 
 func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		writerState:   writerStateStatusLine,
+		writer:        w,
+		version:       "1.1",
+		contentLength: -1,
+	}
+}
+
+// SetProtocol tells the writer which HTTP version the client spoke and whether
+// it asked to keep the connection open, so the status line, body framing and
+// Connection header can match. Without it the writer answers HTTP/1.1 and
+// closes the connection.
+func (w *Writer) SetProtocol(version string, keepAlive bool) {
+	w.version = version
+	w.keepAlive = keepAlive
+}
+
+// SetMethod tells the writer the request method. Responses to HEAD send their
+// headers, Content-Length included, but none of the body bytes handlers write.
+func (w *Writer) SetMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// KeepAlive reports whether the connection can carry another request once the
+// handler is done: the response must be complete and self-delimiting.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive || w.writerState < writerStateBody || w.chunkedOpen {
+		return false
+	}
+	if w.head {
+		return true
+	}
+	return w.contentLength < 0 || w.contentLength == w.bodyWritten
+}
+
+func NewTransportWriter(t Transport) *Writer {
 	return &Writer{
 		writerState: writerStateStatusLine,
-		writer:      w,
+		transport:   t,
 	}
 }
 
@@ -34,21 +105,96 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	}
 	defer func() { w.writerState = writerStateHeaders }()
 
-	data := getStatusLine(statusCode)
+	w.statusCode = statusCode
+	if w.transport != nil {
+		//framed protocols send the status together with the headers
+		return nil
+	}
+	data := getStatusLine(w.version, statusCode)
 	_, err := w.writer.Write(data)
 	return err
 }
 
+// WriteInterim sends a 1xx informational response, such as 100 Continue or
+// 103 Early Hints, ahead of the final status line. HTTP/1.0 clients do not
+// understand them, so nothing is sent to those.
+func (w *Writer) WriteInterim(statusCode StatusCode, h headers.Headers) error {
+	if w.writerState != writerStateStatusLine {
+		return fmt.Errorf("writer is in wrong state: %d", w.writerState)
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == 101 {
+		return fmt.Errorf("not an interim status code: %d", statusCode)
+	}
+	if w.transport != nil {
+		return w.transport.WriteInterim(statusCode, h)
+	}
+	if w.version == "1.0" {
+		return nil
+	}
+	var b strings.Builder
+	b.Write(getStatusLine(w.version, statusCode))
+	for k := range h {
+		for _, v := range h.Values(k) {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	b.WriteString("\r\n")
+	_, err := w.writer.Write([]byte(b.String()))
+	if err != nil {
+		return err
+	}
+	//the client is waiting on this, so don't leave it in a buffer
+	return w.Flush()
+}
+
+// OnWriteHeaders registers fn to run right before the headers go out, so
+// middleware can add headers or cookies based on what the handler did.
+func (w *Writer) OnWriteHeaders(fn func(h headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// StatusCode is the status passed to WriteStatusLine, or 0 if none was written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// SetCookie queues a Set-Cookie header for the next WriteHeaders call.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.writerState > writerStateHeaders {
+		return fmt.Errorf("cannot set cookie after headers are written")
+	}
+	err := c.Valid()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c)
+	return nil
+}
+
 func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.writerState != writerStateHeaders {
 		return fmt.Errorf("writer is in wrong state: %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateBody }()
 
-	for k, v := range h {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
-		if err != nil {
-			return fmt.Errorf("error writing response headers to connection: %v", err)
+	h = maps.Clone(h)
+	for _, hook := range w.headerHooks {
+		hook(h)
+	}
+	for _, c := range w.cookies {
+		h.Set("Set-Cookie", c.String())
+	}
+	if w.transport != nil {
+		return w.transport.WriteHeaders(w.statusCode, h)
+	}
+	h = w.prepareHeaders(h)
+	for k := range h {
+		//each Set-Cookie goes on its own line
+		for _, v := range h.Values(k) {
+			_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
+			if err != nil {
+				return fmt.Errorf("error writing response headers to connection: %v", err)
+			}
 		}
 	}
 	_, err := w.writer.Write([]byte("\r\n"))
@@ -59,14 +205,54 @@ func (w *Writer) WriteBody(p []byte) error {
 	if w.writerState != writerStateBody {
 		return fmt.Errorf("writer is in wrong state: %d", w.writerState)
 	}
-	_, err := w.writer.Write(p)
+	if w.head {
+		w.bodyWritten += len(p)
+		return nil
+	}
+	if w.transport != nil {
+		err := w.transport.WriteData(p)
+		if err == nil {
+			w.sent += len(p)
+		}
+		return err
+	}
+	n, err := w.writer.Write(p)
+	w.bodyWritten += n
+	w.sent += n
 	return err
 }
 
+// BytesWritten returns how many body bytes have been sent, not counting chunk
+// framing. Responses to HEAD send none.
+func (w *Writer) BytesWritten() int {
+	return w.sent
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("writer is in wrong state: %d", w.writerState)
 	}
+	if w.head {
+		return len(p), nil
+	}
+	if w.transport != nil {
+		//framed protocols have their own chunking
+		err := w.transport.WriteData(p)
+		if err != nil {
+			return 0, err
+		}
+		w.sent += len(p)
+		return len(p), nil
+	}
+	if w.unchunked {
+		n, err := w.writer.Write(p)
+		w.sent += n
+		return n, err
+	}
+	if len(p) == 0 {
+		//an empty chunk would read as the end of the body
+		return 0, nil
+	}
 	chunkSize := len(p)
 
 	nTotal := 0
@@ -77,6 +263,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	nTotal += n
 
 	n, err = w.writer.Write(p)
+	w.sent += n
 	if err != nil {
 		return nTotal, err
 	}
@@ -94,6 +281,14 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("writer is in wrong state: %d", w.writerState)
 	}
+	if w.transport != nil || w.head {
+		w.writerState = writerStateTrailers
+		return 0, nil
+	}
+	if w.unchunked {
+		w.writerState = writerStateTrailers
+		return 0, nil
+	}
 	n, err := w.writer.Write([]byte("0\r\n"))
 	if err != nil {
 		fmt.Println("error writing chunked body done")
@@ -109,6 +304,17 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	}
 	defer func() { w.writerState = writerStateBody }()
 
+	if w.head {
+		return nil
+	}
+	if w.transport != nil {
+		return w.transport.WriteTrailers(h)
+	}
+	if w.unchunked {
+		//HTTP/1.0 has nowhere to put trailers
+		return nil
+	}
+	w.chunkedOpen = false
 	for key, value := range h {
 		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
 		if err != nil {
@@ -119,3 +325,53 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	return err
 }
 
+// prepareHeaders adjusts body framing and the Connection header to what the
+// client understands and decides whether the connection stays open.
+func (w *Writer) prepareHeaders(h headers.Headers) headers.Headers {
+	te, _ := h.Get("Transfer-Encoding")
+	chunked := strings.Contains(strings.ToLower(te), "chunked")
+	if chunked && w.version == "1.0" {
+		//HTTP/1.0 has no chunked coding, so the body ends when the connection closes
+		h.Remove("Transfer-Encoding")
+		h.Remove("Trailer")
+		w.unchunked = true
+		chunked = false
+	}
+	w.chunkedOpen = chunked && !w.head
+
+	contentLen, hasLength := h.Get("Content-Length")
+	if hasLength {
+		n, err := strconv.Atoi(contentLen)
+		if err != nil {
+			hasLength = false
+		} else {
+			w.contentLength = n
+		}
+	}
+
+	conn, _ := h.Get("Connection")
+	//a 204 has no body to delimit
+	noBody := w.statusCode == StatusCodeNoContent
+	w.keepAlive = w.keepAlive && (hasLength || chunked || noBody) && !strings.Contains(strings.ToLower(conn), "close")
+	if !w.keepAlive {
+		h.Override("Connection", "close")
+	} else if w.version == "1.0" {
+		h.Override("Connection", "keep-alive")
+	}
+	return h
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Flush pushes any buffered bytes to the client if the underlying writer buffers.
+func (w *Writer) Flush() error {
+	if w.transport != nil {
+		return nil
+	}
+	if f, ok := w.writer.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// Problem describes an error response, with the members of an RFC 9457 problem
+// details object.
+type Problem struct {
+	Type   string `json:"type,omitempty"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ErrorRenderer renders p as a body of one media type.
+type ErrorRenderer func(p Problem) []byte
+
+// ErrorPages renders error responses in the media type the client prefers
+// according to its Accept header. Renderers are registered per status code,
+// with code 0 as the fallback for any code.
+type ErrorPages struct {
+	mu sync.RWMutex
+	//mediaTypes is every registered type, in the order offered to clients
+	mediaTypes []string
+	renderers  map[response.StatusCode]map[string]ErrorRenderer
+}
+
+// NewErrorPages returns pages that render plain text, HTML and
+// application/problem+json for any status code, preferring plain text when the
+// client has no preference.
+func NewErrorPages() *ErrorPages {
+	p := &ErrorPages{renderers: make(map[response.StatusCode]map[string]ErrorRenderer)}
+	p.Handle(0, "text/plain", renderText)
+	p.Handle(0, "text/html", renderHTML)
+	p.Handle(0, "application/problem+json", renderProblemJSON)
+	return p
+}
+
+// DefaultErrorPages renders the server's own errors and those written with
+// Error. Register renderers on it before serving to change how they look.
+var DefaultErrorPages = NewErrorPages()
+
+// Handle registers r to render statusCode errors as mediaType, replacing any
+// renderer already there. Status code 0 registers the fallback for every code.
+func (p *ErrorPages) Handle(statusCode response.StatusCode, mediaType string, r ErrorRenderer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mediaType = strings.ToLower(mediaType)
+	if !slices.Contains(p.mediaTypes, mediaType) {
+		p.mediaTypes = append(p.mediaTypes, mediaType)
+	}
+	if p.renderers[statusCode] == nil {
+		p.renderers[statusCode] = make(map[string]ErrorRenderer)
+	}
+	p.renderers[statusCode][mediaType] = r
+}
+
+// Write sends a complete statusCode response to req, with detail as the
+// human-readable explanation. req may be nil when the request could not be
+// parsed. Detail is shown to the client, so it must not carry internals.
+func (p *ErrorPages) Write(w *response.Writer, req *request.Request, statusCode response.StatusCode, detail string) error {
+	accept := ""
+	if req != nil {
+		accept, _ = req.Headers.Get("Accept")
+	}
+	mediaType, render := p.negotiate(statusCode, accept)
+	body := render(Problem{
+		Title:  response.StatusText(statusCode),
+		Status: int(statusCode),
+		Detail: detail,
+	})
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+	h := response.GetDefaultHeaders(len(body))
+	if strings.HasPrefix(mediaType, "text/") {
+		mediaType += "; charset=utf-8"
+	}
+	h.Override("Content-Type", mediaType)
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	return w.WriteBody(body)
+}
+
+// Error writes a statusCode response with DefaultErrorPages.
+func Error(w *response.Writer, req *request.Request, statusCode response.StatusCode, detail string) error {
+	return DefaultErrorPages.Write(w, req, statusCode, detail)
+}
+
+// negotiate picks the renderer for the media type accept likes best. An error
+// is sent even when nothing is acceptable, in the first type offered.
+func (p *ErrorPages) negotiate(statusCode response.StatusCode, accept string) (string, ErrorRenderer) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var offers []string
+	for _, mediaType := range p.mediaTypes {
+		if p.renderer(statusCode, mediaType) == nil {
+			continue
+		}
+		offers = append(offers, mediaType)
+		if strings.HasSuffix(mediaType, "+json") {
+			//clients asking for JSON get problem+json, which is JSON too
+			offers = append(offers, "application/json")
+		}
+	}
+	if len(offers) == 0 {
+		return "text/plain", renderText
+	}
+
+	best, ok := headers.NegotiateMediaType(accept, offers)
+	if !ok {
+		best = offers[0]
+	}
+	if best == "application/json" && p.renderer(statusCode, best) == nil {
+		best = "application/problem+json"
+	}
+	return best, p.renderer(statusCode, best)
+}
+
+func (p *ErrorPages) renderer(statusCode response.StatusCode, mediaType string) ErrorRenderer {
+	if r := p.renderers[statusCode][mediaType]; r != nil {
+		return r
+	}
+	return p.renderers[0][mediaType]
+}
+
+func renderText(p Problem) []byte {
+	text := fmt.Sprintf("%d %s\n", p.Status, p.Title)
+	if p.Detail != "" {
+		text += p.Detail + "\n"
+	}
+	return []byte(text)
+}
+
+func renderHTML(p Problem) []byte {
+	title := html.EscapeString(p.Title)
+	detail := ""
+	if p.Detail != "" {
+		detail = fmt.Sprintf("\n    <p>%s</p>", html.EscapeString(p.Detail))
+	}
+	return []byte(fmt.Sprintf(`<html>
+  <head>
+    <title>%d %s</title>
+  </head>
+  <body>
+    <h1>%s</h1>%s
+  </body>
+</html>
+`, p.Status, title, title, detail))
+}
+
+func renderProblemJSON(p Problem) []byte {
+	//RFC 9457 says a missing type means about:blank, whose title is the reason phrase
+	body, _ := json.Marshal(p)
+	return append(body, '\n')
+}
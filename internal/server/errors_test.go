@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeErrorPage(t *testing.T, p *ErrorPages, accept string, statusCode response.StatusCode) (string, string) {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if accept != "" {
+		raw += "Accept: " + accept + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, p.Write(w, req, statusCode, "Try <again>."))
+	resp, err := response.ResponseFromReader(&buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, statusCode, resp.StatusLine.StatusCode)
+	ct, _ := resp.Headers.Get("Content-Type")
+	return ct, string(resp.Body)
+}
+
+func TestErrorPages(t *testing.T) {
+	p := NewErrorPages()
+
+	//Test: No preference gets plain text
+	ct, body := writeErrorPage(t, p, "", response.StatusCodeBadRequest)
+	assert.Equal(t, "text/plain; charset=utf-8", ct)
+	assert.Equal(t, "400 Bad Request\nTry <again>.\n", body)
+
+	//Test: Browsers get escaped HTML
+	ct, body = writeErrorPage(t, p, "text/html,application/xhtml+xml,*/*;q=0.8", response.StatusCodeNotFound)
+	assert.Equal(t, "text/html; charset=utf-8", ct)
+	assert.Contains(t, body, "<h1>Not Found</h1>")
+	assert.Contains(t, body, "Try &lt;again&gt;.")
+
+	//Test: JSON clients get problem details
+	ct, body = writeErrorPage(t, p, "application/json", response.StatusCodeInternalServerError)
+	assert.Equal(t, "application/problem+json", ct)
+	var prob Problem
+	require.NoError(t, json.Unmarshal([]byte(body), &prob))
+	assert.Equal(t, Problem{Title: "Internal Server Error", Status: 500, Detail: "Try <again>."}, prob)
+
+	//Test: q-values outrank order, and q=0 excludes
+	ct, _ = writeErrorPage(t, p, "text/plain;q=0.5, text/html", response.StatusCodeBadRequest)
+	assert.Equal(t, "text/html; charset=utf-8", ct)
+	ct, _ = writeErrorPage(t, p, "text/html;q=0, image/png", response.StatusCodeBadRequest)
+	assert.Equal(t, "text/plain; charset=utf-8", ct)
+
+	//Test: A renderer for one status code leaves the others alone
+	p.Handle(response.StatusCodeNotFound, "text/plain", func(Problem) []byte { return []byte("nothing here") })
+	_, body = writeErrorPage(t, p, "", response.StatusCodeNotFound)
+	assert.Equal(t, "nothing here", body)
+	_, body = writeErrorPage(t, p, "", response.StatusCodeBadRequest)
+	assert.Equal(t, "400 Bad Request\nTry <again>.\n", body)
+}
+
+func TestNegotiate(t *testing.T) {
+	m := NewMux()
+	m.Handle("GET", "/report", func(w *response.Writer, req *request.Request) {
+		mediaType, ok := Negotiate(w, req, "application/json", "text/csv")
+		if !ok {
+			return
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(0)
+		h.Override("Content-Type", mediaType)
+		w.WriteHeaders(h)
+	})
+
+	//Test: The best offer is picked and the response varies on Accept
+	out := serveMux(t, m, "GET /report HTTP/1.1\r\nHost: localhost\r\nAccept: text/*\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "content-type: text/csv\r\n")
+	assert.Contains(t, out, "vary: Accept\r\n")
+
+	//Test: Nothing acceptable gets 406
+	out = serveMux(t, m, "GET /report HTTP/1.1\r\nHost: localhost\r\nAccept: text/html\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 406 Not Acceptable\r\n"), out)
+	assert.Contains(t, out, "Available: application/json, text/csv")
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+var errTrailingJSON = errors.New("json: data after the top-level value")
+
+// DecodeJSON reads req's body, at most maxSize bytes of it, into v. The
+// Content-Type must be JSON, fields v has no place for are rejected and so is
+// anything after the value. maxSize 0 leaves the server's limit as it is.
+//
+// maxSize can only stop the read early when the body is still unread, which
+// is the case with WithDeferredBody or a 100-continue request under
+// ContinueOnRead. Otherwise the server has already read the body under its own
+// limit, DefaultMaxBodySize unless WithMaxBodySize changed it, and a body over
+// maxSize is refused after the fact.
+//
+// On failure DecodeJSON has already answered with 415, 413 or a 400 whose
+// detail says what was wrong and where, and the error is only for logging.
+func DecodeJSON(w *response.Writer, req *request.Request, v any, maxSize int) error {
+	ct, _ := req.Headers.Get("Content-Type")
+	if !isJSON(ct) {
+		Error(w, req, response.StatusCodeUnsupportedMediaType, "The body must be application/json.")
+		return fmt.Errorf("content-type is not json: %q", ct)
+	}
+	if limit := req.MaxBodySize(); maxSize > 0 && (limit == 0 || maxSize < limit) {
+		req.SetMaxBodySize(maxSize)
+	}
+	err := req.ReadBody()
+	if errors.Is(err, request.ErrBodyTooLarge) {
+		Error(w, req, response.StatusCodeContentTooLarge, "")
+		return err
+	}
+	if err != nil {
+		Error(w, req, response.StatusCodeBadRequest, "")
+		return err
+	}
+	//a body the server read before the handler ran was under its limit, not ours
+	if maxSize > 0 && len(req.Body) > maxSize {
+		Error(w, req, response.StatusCodeContentTooLarge, "")
+		return request.ErrBodyTooLarge
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err == nil {
+		if _, tokenErr := dec.Token(); tokenErr != io.EOF {
+			err = errTrailingJSON
+		}
+	}
+	if err != nil {
+		Error(w, req, response.StatusCodeBadRequest, jsonErrorDetail(err))
+		return err
+	}
+	return nil
+}
+
+// jsonErrorDetail explains a decoding error to the client in terms of the
+// JSON it sent, not our types.
+func jsonErrorDetail(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errTrailingJSON):
+		return "Unexpected data after the JSON value."
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Malformed JSON at byte %d.", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("Field %q must be %s, not %s.", typeErr.Field, jsonKind(typeErr.Type.Kind().String()), typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("The body must be %s, not %s.", jsonKind(typeErr.Type.Kind().String()), typeErr.Value)
+	case errors.Is(err, io.EOF):
+		return "The body is empty."
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Malformed JSON: the body ends too early."
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return fmt.Sprintf("Unknown field %s.", field)
+	}
+	return "Invalid JSON."
+}
+
+// jsonKind names a Go kind the way a JSON client would think of it.
+func jsonKind(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "slice", kind == "array":
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")
+}
+
+// WriteJSON sends v as a complete JSON response with statusCode. If v can't be
+// encoded the client gets a 500 instead.
+func WriteJSON(w *response.Writer, req *request.Request, statusCode response.StatusCode, v any) error {
+	return WriteJSONIndent(w, req, statusCode, v, "")
+}
+
+// WriteJSONIndent is like WriteJSON but pretty-prints v, indenting with indent.
+func WriteJSONIndent(w *response.Writer, req *request.Request, statusCode response.StatusCode, v any, indent string) error {
+	var body []byte
+	var err error
+	if indent == "" {
+		body, err = json.Marshal(v)
+	} else {
+		body, err = json.MarshalIndent(v, "", indent)
+	}
+	if err != nil {
+		Error(w, req, response.StatusCodeInternalServerError, "")
+		return err
+	}
+	body = append(body, '\n')
+
+	err = w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "application/json")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	return w.WriteBody(body)
+}
+
+// JSONArrayWriter streams a JSON array as a chunked response one element at a
+// time, for results too big to hold in memory. Close must be called to end it.
+type JSONArrayWriter struct {
+	w     *response.Writer
+	count int
+}
+
+// StreamJSONArray sends the status line and headers of a chunked JSON array
+// response and returns the writer for its elements.
+func StreamJSONArray(w *response.Writer, statusCode response.StatusCode) (*JSONArrayWriter, error) {
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return nil, err
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Content-Type", "application/json")
+	h.Override("Transfer-Encoding", "chunked")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONArrayWriter{w: w}, nil
+}
+
+// Write encodes v as the next element. Once part of the array is sent an
+// encoding error can only cut the response short, so check values first if
+// that matters.
+func (a *JSONArrayWriter) Write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := byte(',')
+	if a.count == 0 {
+		sep = '['
+	}
+	a.count++
+	_, err = a.w.WriteChunkedBody(append([]byte{sep}, data...))
+	return err
+}
+
+// Close ends the array and the response.
+func (a *JSONArrayWriter) Close() error {
+	end := "]\n"
+	if a.count == 0 {
+		end = "[]\n"
+	}
+	_, err := a.w.WriteChunkedBody([]byte(end))
+	if err != nil {
+		return err
+	}
+	_, err = a.w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+	return a.w.WriteTrailers(headers.NewHeaders())
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	ID    int `json:"id"`
+	Items []struct {
+		SKU      string `json:"sku"`
+		Quantity int    `json:"quantity"`
+	} `json:"items"`
+}
+
+func postJSON(t *testing.T, contentType, body string) string {
+	t.Helper()
+	m := NewMux()
+	m.Handle("POST", "/orders", func(w *response.Writer, req *request.Request) {
+		var o order
+		if DecodeJSON(w, req, &o, 64) != nil {
+			return
+		}
+		WriteJSON(w, req, response.StatusCodeSuccess, o)
+	})
+	raw := fmt.Sprintf("POST /orders HTTP/1.1\r\nHost: localhost\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+	return serveMux(t, m, raw)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	//Test: A valid body round trips
+	out := postJSON(t, "application/json; charset=utf-8", `{"id":7,"items":[{"sku":"a","quantity":2}]}`)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+`{"id":7,"items":[{"sku":"a","quantity":2}]}`+"\n"), out)
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		status      string
+		detail      string
+	}{
+		{"Wrong content type", "text/plain", `{}`, "415 Unsupported Media Type", "must be application/json"},
+		{"Too large", "application/json", `{"id":1,"items":[` + strings.Repeat(`{},`, 20) + `{}]}`, "413 Content Too Large", ""},
+		{"Unknown field", "application/json", `{"id":1,"colour":"red"}`, "400 Bad Request", `Unknown field "colour".`},
+		{"Wrong type with path", "application/json", `{"items":[{"quantity":"two"}]}`, "400 Bad Request", `Field "items.0.quantity" must be a number, not string.`},
+		{"Syntax error", "application/json", `{"id":1,}`, "400 Bad Request", "Malformed JSON at byte 9."},
+		{"Truncated", "application/json", `{"id":1`, "400 Bad Request", "ends too early"},
+		{"Trailing data", "application/json", `{"id":1}}`, "400 Bad Request", "Unexpected data after the JSON value."},
+		{"Empty", "application/vnd.api+json", ``, "400 Bad Request", "The body is empty."},
+	} {
+		out := postJSON(t, tc.contentType, tc.body)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+"\r\n"), "%s: %s", tc.name, out)
+		assert.Contains(t, out, tc.detail, tc.name)
+	}
+}
+
+func TestDecodeJSONLimit(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		var o order
+		if DecodeJSON(w, req, &o, 64) != nil {
+			return
+		}
+		WriteJSON(w, req, response.StatusCodeSuccess, o)
+	}
+
+	//Test: Servers have a finite body limit unless told otherwise
+	assert.Equal(t, DefaultMaxBodySize, newServer(handler).maxBodySize)
+
+	//Test: With deferred bodies the handler's limit refuses a body before it is read
+	s := newServer(handler, WithDeferredBody())
+	out := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nExpect: 100-continue\r\nContent-Length: 1000\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"), out)
+	assert.NotContains(t, out, "100 Continue")
+	assert.Contains(t, out, "connection: close\r\n")
+}
+
+func TestWriteJSON(t *testing.T) {
+	m := NewMux()
+	m.Handle("GET", "/pretty", func(w *response.Writer, req *request.Request) {
+		WriteJSONIndent(w, req, response.StatusCodeSuccess, map[string]int{"a": 1}, "  ")
+	})
+	m.Handle("GET", "/broken", func(w *response.Writer, req *request.Request) {
+		WriteJSON(w, req, response.StatusCodeSuccess, map[string]any{"f": func() {}})
+	})
+	m.Handle("GET", "/stream", func(w *response.Writer, req *request.Request) {
+		a, err := StreamJSONArray(w, response.StatusCodeSuccess)
+		require.NoError(t, err)
+		for i := range 3 {
+			require.NoError(t, a.Write(map[string]int{"n": i}))
+		}
+		require.NoError(t, a.Close())
+	})
+	m.Handle("GET", "/empty", func(w *response.Writer, req *request.Request) {
+		a, err := StreamJSONArray(w, response.StatusCodeSuccess)
+		require.NoError(t, err)
+		require.NoError(t, a.Close())
+	})
+
+	//Test: Pretty-printing
+	out := serveMux(t, m, "GET /pretty HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n{\n  \"a\": 1\n}\n"), out)
+
+	//Test: Values that can't be encoded get a 500
+	out = serveMux(t, m, "GET /broken HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+
+	//Test: Streamed arrays arrive whole through a chunked body
+	for path, want := range map[string]string{
+		"/stream": `[{"n":0},{"n":1},{"n":2}]` + "\n",
+		"/empty":  "[]\n",
+	} {
+		out = serveMux(t, m, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		resp, err := response.ResponseFromReader(strings.NewReader(out), "GET")
+		require.NoError(t, err)
+		te, _ := resp.Headers.Get("Transfer-Encoding")
+		assert.Equal(t, "chunked", te)
+		assert.Equal(t, want, string(resp.Body))
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/metrics"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// serverMetrics are collected by every server; WithMetrics exposes them.
+type serverMetrics struct {
+	registry          *metrics.Registry
+	requests          *metrics.Counter
+	duration          *metrics.Histogram
+	bytesReceived     *metrics.Counter
+	bytesSent         *metrics.Counter
+	connections       *metrics.Counter
+	activeConnections *metrics.Gauge
+	parseErrors       *metrics.Counter
+	keepAliveReuses   *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:          r,
+		requests:          r.NewCounter("http_requests_total", "Requests served, by method, route and status code.", "method", "route", "status"),
+		duration:          r.NewHistogram("http_request_duration_seconds", "Time from reading the request head to the handler returning.", metrics.DefBuckets, "method", "route"),
+		bytesReceived:     r.NewCounter("http_received_bytes_total", "Bytes read from client connections, TLS included."),
+		bytesSent:         r.NewCounter("http_sent_bytes_total", "Bytes written to client connections, TLS included."),
+		connections:       r.NewCounter("http_connections_total", "Connections accepted."),
+		activeConnections: r.NewGauge("http_active_connections", "Connections currently open."),
+		parseErrors:       r.NewCounter("http_parse_errors_total", "Requests rejected because their head could not be parsed, by error type.", "type"),
+		keepAliveReuses:   r.NewCounter("http_keepalive_reuses_total", "Requests that arrived on a connection that had already served one."),
+	}
+}
+
+// observe records a finished request. Requests no Mux route matched are
+// labelled with route "none".
+func (m *serverMetrics) observe(w *response.Writer, method string, route *routeInfo, start time.Time) {
+	pattern := route.pattern
+	if pattern == "" {
+		pattern = "none"
+	}
+	m.requests.Inc(method, pattern, strconv.Itoa(int(w.StatusCode())))
+	m.duration.Observe(time.Since(start).Seconds(), method, pattern)
+}
+
+// methodLabel is the method label for a request. Methods the server doesn't
+// serve are all "other", so clients can't add a series per made-up method.
+func (s *Server) methodLabel(req *request.Request) string {
+	if !s.implemented(req.RequestLine.Method) {
+		return "other"
+	}
+	return req.RequestLine.Method
+}
+
+// serveMetrics answers GET and HEAD requests for the metrics path and passes
+// everything else to next.
+func (s *Server) serveMetrics(next Handler) Handler {
+	serve := s.metrics.registry.Handler()
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		if req.Target.Path != s.metricsPath || method != "GET" && method != "HEAD" {
+			next(w, req)
+			return
+		}
+		setRoute(req, s.metricsPath)
+		serve(w, req)
+	}
+}
+
+// parseErrorType names an error from reading a request head for the
+// http_parse_errors_total type label.
+func parseErrorType(err error) string {
+	switch {
+	case errors.Is(err, request.ErrVersionNotSupported):
+		return "version_not_supported"
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		return "unsupported_transfer_coding"
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return "header_too_large"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	default:
+		return "malformed"
+	}
+}
+
+// routeInfo lets the Mux tell the server which pattern served a request. It is
+// shared through the request context, so it survives middleware that copies
+// the request with WithContext.
+type routeInfo struct {
+	pattern string
+}
+
+type routeKey struct{}
+
+func withRouteInfo(ctx context.Context) (context.Context, *routeInfo) {
+	route := &routeInfo{}
+	return context.WithValue(ctx, routeKey{}, route), route
+}
+
+func setRoute(req *request.Request, pattern string) {
+	if route, ok := req.Context().Value(routeKey{}).(*routeInfo); ok {
+		route.pattern = pattern
+	}
+}
+
+// countingListener counts the connections it accepts and the bytes that go
+// through them.
+type countingListener struct {
+	net.Listener
+	metrics *serverMetrics
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.metrics.connections.Inc()
+	return &countingConn{Conn: conn, metrics: l.metrics}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	metrics *serverMetrics
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.metrics.bytesReceived.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.metrics.bytesSent.Add(float64(n))
+	return n, err
+}
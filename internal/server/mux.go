@@ -0,0 +1,137 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// Mux routes requests by path and method. A pattern ending in "/" matches
+// every path under it; other patterns match only that exact path. The longest
+// matching pattern wins.
+//
+// Mux answers OPTIONS (including OPTIONS *) with an Allow header built from the
+// registered methods, replies 405 to methods a path has no handler for, and
+// serves HEAD with the GET handler when no HEAD handler is registered.
+type Mux struct {
+	routes map[string]map[string]Handler
+	//NotFound handles paths with no matching pattern
+	NotFound Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{
+		routes:   make(map[string]map[string]Handler),
+		NotFound: notFound,
+	}
+}
+
+// Handle registers h for method requests to paths matching pattern.
+func (m *Mux) Handle(method, pattern string, h Handler) {
+	if m.routes[pattern] == nil {
+		m.routes[pattern] = make(map[string]Handler)
+	}
+	m.routes[pattern][method] = h
+}
+
+// Serve is the Mux's Handler.
+func (m *Mux) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if req.Target.Form == request.TargetFormAsterisk {
+		if method == "OPTIONS" {
+			writeAllow(w, req, response.StatusCodeNoContent, m.allMethods())
+			return
+		}
+		m.NotFound(w, req)
+		return
+	}
+
+	pattern, handlers, ok := m.match(req.Target.Path)
+	if !ok {
+		m.NotFound(w, req)
+		return
+	}
+	setRoute(req, pattern)
+	h, ok := handlers[method]
+	if !ok && method == "HEAD" {
+		h, ok = handlers["GET"]
+	}
+	if ok {
+		h(w, req)
+		return
+	}
+	if method == "OPTIONS" {
+		writeAllow(w, req, response.StatusCodeNoContent, allowed(handlers))
+		return
+	}
+	writeAllow(w, req, response.StatusCodeMethodNotAllowed, allowed(handlers))
+}
+
+func (m *Mux) match(path string) (string, map[string]Handler, bool) {
+	if handlers, ok := m.routes[path]; ok {
+		return path, handlers, true
+	}
+	best := ""
+	for pattern := range m.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return "", nil, false
+	}
+	return best, m.routes[best], true
+}
+
+func (m *Mux) allMethods() []string {
+	methods := []string{}
+	for _, handlers := range m.routes {
+		for _, method := range allowed(handlers) {
+			if !slices.Contains(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+// allowed lists the methods a route answers, counting the ones Mux adds.
+func allowed(handlers map[string]Handler) []string {
+	methods := []string{"OPTIONS"}
+	for method := range handlers {
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	if _, ok := handlers["GET"]; ok && !slices.Contains(methods, "HEAD") {
+		methods = append(methods, "HEAD")
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+func writeAllow(w *response.Writer, req *request.Request, statusCode response.StatusCode, methods []string) {
+	allow := strings.Join(methods, ", ")
+	if statusCode != response.StatusCodeNoContent {
+		w.OnWriteHeaders(func(h headers.Headers) {
+			h.Override("Allow", allow)
+		})
+		Error(w, req, statusCode, "")
+		return
+	}
+	w.WriteStatusLine(statusCode)
+	h := response.GetDefaultHeaders(0)
+	h.Override("Allow", allow)
+	//204 responses carry no body, so no Content-Length or Content-Type either
+	h.Remove("Content-Length")
+	h.Remove("Content-Type")
+	w.WriteHeaders(h)
+}
+
+func notFound(w *response.Writer, req *request.Request) {
+	Error(w, req, response.StatusCodeNotFound, "")
+}
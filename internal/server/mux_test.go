@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveMux(t *testing.T, m *Mux, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetProtocol(req.RequestLine.HttpVersion, req.KeepAlive())
+	w.SetMethod(req.RequestLine.Method)
+	m.Serve(w, req)
+	return buf.String()
+}
+
+func textHandler(text string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(text)))
+		w.WriteBody([]byte(text))
+	}
+}
+
+func TestMux(t *testing.T) {
+	m := NewMux()
+	m.Handle("GET", "/", textHandler("root"))
+	m.Handle("GET", "/files/", textHandler("files"))
+	m.Handle("GET", "/files/readme", textHandler("readme"))
+	m.Handle("POST", "/upload", textHandler("upload"))
+
+	//Test: Exact match beats prefix match
+	out := serveMux(t, m, "GET /files/readme HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nreadme"))
+
+	//Test: Longest prefix wins
+	out = serveMux(t, m, "GET /files/a/b HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nfiles"))
+
+	//Test: HEAD uses the GET handler and keeps Content-Length but drops the body
+	out = serveMux(t, m, "HEAD /files/readme HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "content-length: 6\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	//Test: Wrong method gets 405 with Allow
+	out = serveMux(t, m, "GET /upload HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: OPTIONS, POST\r\n")
+
+	//Test: OPTIONS on a route lists its methods
+	out = serveMux(t, m, "OPTIONS /files/readme HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS\r\n")
+	assert.NotContains(t, out, "content-length")
+
+	//Test: OPTIONS * lists every registered method
+	out = serveMux(t, m, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	//Test: Unknown path
+	m = NewMux()
+	m.Handle("GET", "/only", textHandler("only"))
+	out = serveMux(t, m, "GET /other HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestHeadChunked(t *testing.T) {
+	m := NewMux()
+	m.Handle("GET", "/stream", func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Override("Transfer-Encoding", "chunked")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+	})
+	out := serveMux(t, m, "HEAD /stream HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.NotContains(t, out, "hello")
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// Negotiate picks which of the offered media types to send in reply to req,
+// going by its Accept header. The response gets Vary: Accept. When no offer is
+// acceptable Negotiate answers 406 Not Acceptable itself and returns false.
+func Negotiate(w *response.Writer, req *request.Request, offers ...string) (string, bool) {
+	w.OnWriteHeaders(func(h headers.Headers) {
+		h.Set("Vary", "Accept")
+	})
+	accept, _ := req.Headers.Get("Accept")
+	best, ok := headers.NegotiateMediaType(accept, offers)
+	if !ok {
+		Error(w, req, response.StatusCodeNotAcceptable, "Available: "+strings.Join(offers, ", "))
+		return "", false
+	}
+	return best, true
+}
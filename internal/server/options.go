@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/tls"
+	"slices"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/http2"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+type Option func(*Server)
+
+// WithTLSConfig serves HTTPS and offers HTTP/2 to clients via ALPN.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		cfg = cfg.Clone()
+		for _, proto := range []string{http2.NextProto, "http/1.1"} {
+			if !slices.Contains(cfg.NextProtos, proto) {
+				cfg.NextProtos = append(cfg.NextProtos, proto)
+			}
+		}
+		s.tlsConfig = cfg
+	}
+}
+
+// WithH2C accepts cleartext HTTP/2 from clients that send the connection
+// preface directly (prior knowledge). Meant for local testing.
+func WithH2C() Option {
+	return func(s *Server) {
+		s.h2c = true
+	}
+}
+
+// WithRequestTimeout cancels each request's context once d has passed since its
+// headers were read. Handlers see it through Request.Context.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithErrorHandler sets what answers a request whose handler panicked before
+// writing its status line. The default sends a 500 from DefaultErrorPages.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(s *Server) {
+		s.errorHandler = h
+	}
+}
+
+// DefaultMaxBodySize is the request body limit unless WithMaxBodySize says
+// otherwise.
+const DefaultMaxBodySize = 10 << 20
+
+// WithMaxBodySize answers requests with bodies over n bytes with 413 Content
+// Too Large. Handlers reading the body themselves get request.ErrBodyTooLarge.
+// Zero means no limit.
+func WithMaxBodySize(n int) Option {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// AccessLogFunc is told about a response once it is complete. start is when
+// the request head was read.
+type AccessLogFunc func(w *response.Writer, req *request.Request, start time.Time)
+
+// WithAccessLog calls fn for every response, including the ones the server
+// sends without running the handler: 400, 408, 431, 501 and 505 for heads it
+// can't parse or serve, and 413 for bodies over the limit. Requests whose head
+// couldn't be parsed have an empty RequestLine. Middleware only sees requests
+// that reach the handler.
+func WithAccessLog(fn AccessLogFunc) Option {
+	return func(s *Server) {
+		s.accessLog = fn
+	}
+}
+
+// WithMetrics serves the server's metrics in the Prometheus text format at
+// path, ahead of the handler.
+func WithMetrics(path string) Option {
+	return func(s *Server) {
+		s.metricsPath = path
+	}
+}
+
+// WithDeferredBody leaves every request body unread until the handler reads
+// it with ReadBody, BodyReader or MultipartReader, so large uploads can be
+// streamed and handlers can set their own limit with SetMaxBodySize first.
+// Handlers must then not use req.Body without calling ReadBody.
+func WithDeferredBody() Option {
+	return func(s *Server) {
+		s.deferBody = true
+	}
+}
+
+// ContinuePolicy decides when the server answers Expect: 100-continue.
+type ContinuePolicy int
+
+const (
+	// ContinueOnRead sends 100 Continue when the handler first reads the body,
+	// so the handler can reject the request (say with 413) before it is sent.
+	ContinueOnRead ContinuePolicy = iota
+	// ContinueImmediately sends 100 Continue and reads the body before the
+	// handler runs, like any other request.
+	ContinueImmediately
+)
+
+// WithContinuePolicy sets when 100 Continue is sent. The default is ContinueOnRead.
+func WithContinuePolicy(policy ContinuePolicy) Option {
+	return func(s *Server) {
+		s.continuePolicy = policy
+	}
+}
+
+// DefaultMethods are the methods a server accepts unless WithMethods says otherwise.
+var DefaultMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
+// WithMethods replaces the allow-list of request methods. Requests with any
+// other method get 501 Not Implemented before the handler runs. Extension
+// methods such as WebDAV's PROPFIND must be listed here to reach handlers.
+func WithMethods(methods ...string) Option {
+	return func(s *Server) {
+		s.methods = slices.Clone(methods)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// ErrorHandler answers a request whose handler failed. It only runs while
+// nothing has been written, so it is free to write any response.
+type ErrorHandler func(w *response.Writer, req *request.Request, err error)
+
+// PanicError is the error an ErrorHandler gets for a handler that panicked.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+func defaultErrorHandler(w *response.Writer, req *request.Request, err error) {
+	Error(w, req, response.StatusCodeInternalServerError, "")
+}
+
+// callHandler runs h and turns a panic into a PanicError, taking the stack
+// while the panicking frames are still on it.
+func callHandler(h Handler, w *response.Writer, req *request.Request) (perr *PanicError) {
+	defer func() {
+		if v := recover(); v != nil {
+			perr = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	h(w, req)
+	return nil
+}
+
+// handlePanic logs perr and, if the status line is not out yet, lets the error
+// handler answer. It reports whether the response was completed; if not, the
+// caller has to abort it so the client can't mistake it for a whole one.
+func (s *Server) handlePanic(w *response.Writer, req *request.Request, perr *PanicError) bool {
+	if perr.Value == response.ErrAbortHandler {
+		return false
+	}
+	log.Printf("panic serving %s: %v\n%s", describe(req), perr.Value, perr.Stack)
+	if w.StatusCode() != 0 {
+		return false
+	}
+	//whatever the handler left unread, the connection is not reused
+	w.SetProtocol(req.RequestLine.HttpVersion, false)
+	errPerr := callHandler(func(w *response.Writer, req *request.Request) {
+		s.errorHandler(w, req, perr)
+	}, w, req)
+	if errPerr != nil {
+		log.Printf("panic in error handler for %s: %v\n%s", describe(req), errPerr.Value, errPerr.Stack)
+		return false
+	}
+	return true
+}
+
+// describe names req in log lines, with its ID so that they can be matched
+// with the access log and with what the client was told.
+func describe(req *request.Request) string {
+	if req.ID == "" {
+		return req.RequestLine.Method + " " + req.RequestLine.RequestTarget
+	}
+	return fmt.Sprintf("%s %s (request %s)", req.RequestLine.Method, req.RequestLine.RequestTarget, req.ID)
+}
+
+// abort drops conn with a TCP reset instead of a clean close, so a response cut
+// short is not taken for a complete close-delimited one.
+func abort(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if countingConn, ok := conn.(*countingConn); ok {
+		conn = countingConn.Conn
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
@@ -1,11 +1,22 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/http2"
 	"github.com/JA50N14/httpfromtcp/internal/request"
 	"github.com/JA50N14/httpfromtcp/internal/response"
 )
@@ -14,21 +25,63 @@ type Server struct {
 	listener net.Listener
 	handler  Handler
 	closed   atomic.Bool
+
+	tlsConfig      *tls.Config
+	h2c            bool
+	continuePolicy ContinuePolicy
+	deferBody      bool
+	//methods is the allow-list of request methods, anything else gets 501
+	methods        []string
+	requestTimeout time.Duration
+	maxBodySize    int
+	errorHandler   ErrorHandler
+	metrics        *serverMetrics
+	metricsPath    string
+	accessLog      AccessLogFunc
+
+	//ctx is the parent of every request context and is cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	//mu guards conns, the live connections and whether each is idle, that is
+	//not in the middle of a request
+	mu    sync.Mutex
+	conns map[net.Conn]bool
 }
 
+// idleTimeout is how long a persistent connection may sit between requests.
+const idleTimeout = 2 * time.Minute
+
+// headerTimeout is how long a client has to send the request line and headers
+// once it has started a request.
+const headerTimeout = 30 * time.Second
+
 type Handler func(w *response.Writer, req *request.Request)
 
+// Middleware wraps a Handler with behaviour that runs around it.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h so that the first middleware is the outermost one.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts...)
 
-func Serve(port int, handler Handler) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
-
-	s := &Server{
-		listener: listener,
-		handler:  handler,
+	listener = &countingListener{Listener: listener, metrics: s.metrics}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
 	}
+	s.listener = listener
 	s.closed.Store(false)
 
 	go s.listen()
@@ -36,14 +89,64 @@ func Serve(port int, handler Handler) (*Server, error) {
 	return s, nil
 }
 
+func newServer(handler Handler, opts ...Option) *Server {
+	s := &Server{
+		handler:      handler,
+		methods:      DefaultMethods,
+		maxBodySize:  DefaultMaxBodySize,
+		errorHandler: defaultErrorHandler,
+		metrics:      newServerMetrics(),
+		conns:        make(map[net.Conn]bool),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.metricsPath != "" {
+		s.handler = s.serveMetrics(s.handler)
+	}
+	return s
+}
+
+// Close stops accepting connections and cancels the context of every request
+// still being handled. Idle connections are closed, HTTP/1 connections close
+// once their current request is done, and HTTP/2 connections are sent GOAWAY.
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	s.cancel()
+	s.mu.Lock()
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
 	if s.listener != nil {
 		return s.listener.Close()
 	}
 	return nil
 }
 
+// setIdle records whether conn is between requests and reports whether it
+// may carry on, which it may not once the server is closed. Close and setIdle
+// both hold mu, so a connection going idle is either closed by Close or sees
+// that the server is.
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return false
+	}
+	s.conns[conn] = idle
+	return true
+}
+
+func (s *Server) forget(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
@@ -61,18 +164,270 @@ func (s *Server) listen() {
 
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
-	w := response.NewWriter(conn)
-	
-	req, err := request.RequestFromReader(conn)
+	if !s.setIdle(conn, true) {
+		return
+	}
+	defer s.forget(conn)
+	s.metrics.activeConnections.Inc()
+	defer s.metrics.activeConnections.Dec()
+	defer func() {
+		//handler panics are dealt with per request, this catches the rest
+		if v := recover(); v != nil {
+			log.Printf("panic serving connection from %v: %v\n%s", conn.RemoteAddr(), v, debug.Stack())
+		}
+	}()
+	connCtx, cancelConn := context.WithCancel(s.ctx)
+	defer cancelConn()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(headerTimeout))
+		err := tlsConn.Handshake()
+		if err != nil {
+			log.Printf("tls handshake with %v failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProto {
+			//connCtx ending on Close is what shuts HTTP/2 connections down
+			if !s.setIdle(conn, false) {
+				return
+			}
+			http2.ServeConn(connCtx, conn, http2.Handler(s.serveHTTP2))
+			return
+		}
+	}
+
+	reader := bufio.NewReaderSize(conn, request.ReadBufferSize)
+	served := 0
+	if s.h2c {
+		//waiting for the preface is waiting for the first request
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if hasHTTP2Preface(reader) {
+			conn.SetReadDeadline(time.Time{})
+			if !s.setIdle(conn, false) {
+				return
+			}
+			http2.ServeConn(connCtx, &bufferedConn{Conn: conn, reader: reader}, http2.Handler(s.serveHTTP2))
+			return
+		}
+		if reader.Buffered() == 0 {
+			//closed or timed out before sending anything
+			return
+		}
+	}
+
+	for {
+		if !s.setIdle(conn, true) {
+			return
+		}
+		//a client closing an idle connection is not an error
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		_, err := reader.Peek(1)
+		if err != nil || !s.setIdle(conn, false) {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(headerTimeout))
+
+		w := response.NewWriter(conn)
+		start := time.Now()
+		req, err := request.RequestHeadFromReader(reader)
+		if err != nil {
+			s.metrics.parseErrors.Inc(parseErrorType(err))
+			Error(w, nil, headError(err), "")
+			s.logAccess(w, &request.Request{Headers: headers.NewHeaders(), RemoteAddr: conn.RemoteAddr().String()}, start)
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		if served > 0 {
+			s.metrics.keepAliveReuses.Inc()
+		}
+		served++
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if !s.serveRequest(connCtx, conn, reader, w, req) {
+			return
+		}
+	}
+}
+
+// serveRequest runs the handler for one HTTP/1 request and reports whether the
+// connection can carry another.
+func (s *Server) serveRequest(connCtx context.Context, conn net.Conn, reader *bufio.Reader, w *response.Writer, req *request.Request) bool {
+	ctx, cancel := s.requestContext(connCtx)
+	defer cancel()
+	ctx, route := withRouteInfo(ctx)
+	req = req.WithContext(ctx)
+	defer s.metrics.observe(w, s.methodLabel(req), route, time.Now())
+	defer s.logAccess(w, req, time.Now())
+
+	w.SetProtocol(req.RequestLine.HttpVersion, req.KeepAlive())
+	w.OnWriteHeaders(func(h headers.Headers) {
+		//a closed server takes no more requests on this connection
+		if s.closed.Load() {
+			h.Override("Connection", "close")
+		}
+	})
+	w.SetMethod(req.RequestLine.Method)
+	if !s.implemented(req.RequestLine.Method) {
+		Error(w, req, response.StatusCodeNotImplemented, "")
+		return false
+	}
+
+	err := s.prepareBody(w, req)
 	if err != nil {
-		w.WriteStatusLine(response.StatusCodeBadRequest)
-		respBody := []byte(fmt.Sprintf("error parsing request: %v", err))
-		headers := response.GetDefaultHeaders(len(respBody))
-		w.WriteHeaders(headers)
-		w.WriteBody(respBody)
+		return false
+	}
+	if req.BodyRead() {
+		//nothing else reads the connection until the handler is done
+		stop := watchClose(conn, reader, cancel)
+		defer stop()
+	}
+	if perr := callHandler(s.handler, w, req); perr != nil {
+		if !s.handlePanic(w, req, perr) {
+			abort(conn)
+		}
+		return false
+	}
+	//an unread body is still on the connection, or never coming
+	return req.BodyRead() && w.KeepAlive()
+}
+
+func (s *Server) requestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.requestTimeout > 0 {
+		return context.WithTimeout(parent, s.requestTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// watchClose reads ahead on the connection while a handler runs so that a
+// client hanging up cancels the request context. Bytes that arrive instead,
+// such as a pipelined request, stay buffered in reader. The returned stop
+// function must be called before reader is used again.
+func watchClose(conn net.Conn, reader *bufio.Reader, cancel context.CancelFunc) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := reader.Peek(1)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel()
+		}
+	}()
+	return func() {
+		//unblock the read without closing the connection
+		conn.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func (s *Server) serveHTTP2(w *response.Writer, req *request.Request) {
+	ctx, cancel := s.requestContext(req.Context())
+	defer cancel()
+	ctx, route := withRouteInfo(ctx)
+	req = req.WithContext(ctx)
+	defer s.metrics.observe(w, s.methodLabel(req), route, time.Now())
+	defer s.logAccess(w, req, time.Now())
+	if !s.implemented(req.RequestLine.Method) {
+		Error(w, req, response.StatusCodeNotImplemented, "")
+		return
+	}
+	if s.prepareBody(w, req) != nil {
 		return
 	}
+	if perr := callHandler(s.handler, w, req); perr != nil && !s.handlePanic(w, req, perr) {
+		//the http2 package resets the stream
+		panic(response.ErrAbortHandler)
+	}
+}
+
+func (s *Server) logAccess(w *response.Writer, req *request.Request, start time.Time) {
+	if s.accessLog != nil {
+		s.accessLog(w, req, start)
+	}
+}
+
+func (s *Server) implemented(method string) bool {
+	return slices.Contains(s.methods, method)
+}
+
+// prepareBody applies the Expect header: with 100-continue and ContinueOnRead,
+// or with WithDeferredBody, the body is left for the handler to read,
+// otherwise it is read now. Any error has already been answered.
+func (s *Server) prepareBody(w *response.Writer, req *request.Request) error {
+	req.SetMaxBodySize(s.maxBodySize)
+	expect, ok := req.Headers.Get("Expect")
+	if ok && req.RequestLine.HttpVersion != "1.0" && !req.ExpectContinue() {
+		Error(w, req, response.StatusCodeExpectationFailed, "Only 100-continue is supported.")
+		return fmt.Errorf("unsupported expectation: %s", expect)
+	}
+	if req.ExpectContinue() {
+		req.OnReadBody(func() error {
+			if w.StatusCode() != 0 {
+				//the handler already answered, so the client gets no 100
+				return nil
+			}
+			return w.WriteInterim(response.StatusCodeContinue, nil)
+		})
+	}
+	if s.deferBody || (req.ExpectContinue() && s.continuePolicy == ContinueOnRead) {
+		w.OnWriteHeaders(func(h headers.Headers) {
+			if !req.BodyRead() {
+				h.Override("Connection", "close")
+			}
+		})
+		return nil
+	}
+	err := req.ReadBody()
+	if err != nil {
+		//the rest of the body is still on the connection
+		w.SetProtocol(req.RequestLine.HttpVersion, false)
+		statusCode := response.StatusCodeBadRequest
+		if errors.Is(err, request.ErrBodyTooLarge) {
+			statusCode = response.StatusCodeContentTooLarge
+		} else if errors.Is(err, request.ErrHeaderTooLarge) {
+			//trailers over the section limits
+			statusCode = response.StatusCodeRequestHeaderFieldsTooLarge
+		}
+		Error(w, req, statusCode, "")
+		return err
+	}
+	return nil
+}
+
+// headError maps an error reading a request head to the status code that
+// answers it.
+func headError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrVersionNotSupported):
+		return response.StatusCodeHTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		return response.StatusCodeNotImplemented
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusCodeRequestHeaderFieldsTooLarge
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.StatusCodeRequestTimeout
+	default:
+		return response.StatusCodeBadRequest
+	}
+}
+
+// hasHTTP2Preface peeks one byte at a time so an HTTP/1.1 request shorter than
+// the preface never blocks waiting for bytes that will not come.
+func hasHTTP2Preface(r *bufio.Reader) bool {
+	for n := 1; n <= len(http2.ClientPreface); n++ {
+		b, err := r.Peek(n)
+		if err != nil || string(b) != http2.ClientPreface[:n] {
+			return false
+		}
+	}
+	return true
+}
+
+// bufferedConn hands bytes already peeked by a bufio.Reader back to the next reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
 
-	s.handler(w, req)
-	return
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
 }
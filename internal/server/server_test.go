@@ -0,0 +1,354 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip feeds raw to a connection served by s and returns everything the
+// server writes until it closes the connection.
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handle(conn)
+	go func() {
+		client.Write([]byte(raw))
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.Method)
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestMethodAllowList(t *testing.T) {
+	//Test: Unknown methods get 501 without reaching the handler
+	s := newServer(okHandler)
+	out := roundTrip(t, s, "PROPFIND / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"), out)
+
+	//Test: Extension methods can be allowed
+	s = newServer(okHandler, WithMethods(append(DefaultMethods, "PROPFIND")...))
+	out = roundTrip(t, s, "PROPFIND / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "PROPFIND"))
+
+	//Test: Malformed methods are a bad request
+	out = roundTrip(t, s, "GE(T / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+}
+
+func TestMessageFraming(t *testing.T) {
+	s := newServer(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+		w.WriteBody(req.Body)
+	})
+
+	//Test: Chunked request bodies reach the handler decoded
+	out := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"), out)
+
+	//Test: Ambiguous framing is a bad request and closes the connection
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+
+	//Test: Transfer codings we can't decode are not implemented
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"), out)
+}
+
+func TestRequestContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+	waitForCancel := func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- req.Context().Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+	}
+
+	//Test: The client hanging up cancels the context
+	s := newServer(waitForCancel)
+	client, conn := net.Pipe()
+	go s.handle(conn)
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	client.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	//Test: The request timeout cancels the context
+	s = newServer(waitForCancel, WithRequestTimeout(10*time.Millisecond))
+	client, conn = net.Pipe()
+	go s.handle(conn)
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.ErrorIs(t, <-cancelled, context.DeadlineExceeded)
+	client.Close()
+
+	//Test: Closing the server cancels the context
+	started := make(chan struct{})
+	s = newServer(func(w *response.Writer, req *request.Request) {
+		close(started)
+		waitForCancel(w, req)
+	})
+	client, conn = net.Pipe()
+	go s.handle(conn)
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	//a connection that hasn't got to its request yet would just be closed
+	<-started
+	s.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+	client.Close()
+}
+
+func TestClose(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := newServer(func(w *response.Writer, req *request.Request) {
+		if req.Target.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		okHandler(w, req)
+	})
+
+	//Test: Idle keep-alive connections are closed
+	idle, conn := net.Pipe()
+	defer idle.Close()
+	idle.SetDeadline(time.Now().Add(5 * time.Second))
+	go s.handle(conn)
+	_, err := idle.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(idle)
+	_, err = response.ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+
+	busy, conn := net.Pipe()
+	defer busy.Close()
+	busy.SetDeadline(time.Now().Add(5 * time.Second))
+	go s.handle(conn)
+	_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	s.Close()
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	//Test: A request in progress is answered, then its connection is closed
+	close(release)
+	out, err := io.ReadAll(busy)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"), string(out))
+	assert.Contains(t, string(out), "connection: close\r\n")
+}
+
+func TestPipelinedWhileHandling(t *testing.T) {
+	//a request arriving while the handler runs must not cancel it or be lost
+	s := newServer(func(w *response.Writer, req *request.Request) {
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, req.Context().Err())
+		okHandler(w, req)
+	})
+	out := roundTrip(t, s, "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"), out)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	s := newServer(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/late" {
+			w.WriteStatusLine(response.StatusCodeSuccess)
+			w.WriteHeaders(response.GetDefaultHeaders(10))
+			w.WriteBody([]byte("part"))
+		}
+		panic("boom")
+	})
+
+	//Test: A panic before the status line is answered with 500 and closes the connection
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.Contains(t, out, "connection: close\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+
+	//Test: A panic after the status line aborts the response
+	out = roundTrip(t, s, "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "part"), out)
+
+	//Test: A custom error handler gets the panic
+	var got error
+	s = newServer(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, WithErrorHandler(func(w *response.Writer, req *request.Request, err error) {
+		got = err
+		w.WriteStatusLine(response.StatusCodeNotImplemented)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}))
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 "), out)
+	var perr *PanicError
+	require.ErrorAs(t, got, &perr)
+	assert.Equal(t, "boom", perr.Value)
+	assert.Contains(t, string(perr.Stack), "TestPanicRecovery")
+
+	//Test: ErrAbortHandler aborts without an answer
+	s = newServer(func(w *response.Writer, req *request.Request) {
+		panic(response.ErrAbortHandler)
+	})
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Empty(t, out)
+}
+
+// deadlineConn records the read deadline in force at the first Read.
+type deadlineConn struct {
+	net.Conn
+	deadline  time.Time
+	firstRead chan time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	select {
+	case c.firstRead <- c.deadline:
+	default:
+	}
+	return c.Conn.Read(p)
+}
+
+func TestH2CPreface(t *testing.T) {
+	s := newServer(okHandler, WithH2C())
+
+	//Test: HTTP/1.1 requests are still served
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+
+	//Test: A client that sends nothing is not waited on forever
+	client, conn := net.Pipe()
+	defer client.Close()
+	dc := &deadlineConn{Conn: conn, firstRead: make(chan time.Time, 1)}
+	go s.handle(dc)
+	select {
+	case deadline := <-dc.firstRead:
+		assert.False(t, deadline.IsZero(), "read without a deadline")
+	case <-time.After(2 * time.Second):
+		t.Fatal("server never read the connection")
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s := newServer(okHandler, WithMaxBodySize(4))
+
+	//Test: Parse errors don't leak parser internals
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nBad Header: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n400 Bad Request\n"), out)
+
+	//Test: Over-long header lines get 431
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: "+strings.Repeat("a", request.ReadBufferSize)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large\r\n"), out)
+
+	//Test: Too many header fields get 431 too
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n"+strings.Repeat("X: a\r\n", headers.MaxFields)+"\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large\r\n"), out)
+
+	//Test: Bodies over the limit get 413 in the format asked for
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nAccept: application/json\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"), out)
+	assert.Contains(t, out, "content-type: application/problem+json\r\n")
+	assert.Contains(t, out, `"status":413`)
+}
+
+func TestDeferredBody(t *testing.T) {
+	s := newServer(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/skip" {
+			okHandler(w, req)
+			return
+		}
+		req.SetMaxBodySize(10)
+		body, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			Error(w, req, response.StatusCodeContentTooLarge, "")
+			return
+		}
+		textHandler(string(body))(w, req)
+	}, WithMaxBodySize(4), WithDeferredBody())
+
+	//Test: The handler reads the body itself, under its own limit
+	out := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"+
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "\r\n\r\nhello")
+	assert.Contains(t, out, "HTTP/1.1 413 Content Too Large\r\n")
+
+	//Test: A body the handler leaves unread closes the connection
+	out = roundTrip(t, s, "POST /skip HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "connection: close\r\n")
+}
+
+func TestAccessLog(t *testing.T) {
+	var logged []string
+	s := newServer(okHandler, WithMaxBodySize(4), WithMethods("GET", "POST"), WithAccessLog(func(w *response.Writer, req *request.Request, start time.Time) {
+		logged = append(logged, fmt.Sprintf("%s %d", req.RequestLine.Method, w.StatusCode()))
+	}))
+
+	//Test: Responses the server sends without the handler are logged too
+	roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	roundTrip(t, s, "PUT / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nBad Header: x\r\n\r\n")
+	roundTrip(t, s, "GET / HTTP/9.9\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"GET 200", "POST 413", "PUT 501", " 400", " 505"}, logged)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMux()
+	m.Handle("GET", "/items/", textHandler("item"))
+	s := newServer(m.Serve, WithMetrics("/metrics"))
+
+	roundTrip(t, s, "GET /items/1 HTTP/1.1\r\nHost: localhost\r\n\r\nGET /items/2 HTTP/1.1\r\nHost: localhost\r\n\r\nGET /nope HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	roundTrip(t, s, "GET / HTTP/9.9\r\nHost: localhost\r\n\r\n")
+	roundTrip(t, s, "BREW /items/1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	roundTrip(t, s, "WHEN /items/1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	out := roundTrip(t, s, "GET /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "content-type: text/plain; version=0.0.4; charset=utf-8\r\n")
+	//Test: Requests are labelled with the route pattern, not the path
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/items/",status="200"} 2`+"\n")
+	assert.Contains(t, out, `http_requests_total{method="GET",route="none",status="404"} 1`+"\n")
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/items/"} 2`+"\n")
+	//Test: Methods the server doesn't serve share one label
+	assert.Contains(t, out, `http_requests_total{method="other",route="none",status="501"} 2`+"\n")
+	assert.NotContains(t, out, "BREW")
+	assert.Contains(t, out, `http_keepalive_reuses_total 2`+"\n")
+	assert.Contains(t, out, `http_parse_errors_total{type="version_not_supported"} 1`+"\n")
+	//the connection serving this request is still open
+	assert.Contains(t, out, "http_active_connections 1\n")
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+// VirtualHosts picks a handler by the request's host, so several sites can be
+// served from one server. Patterns are an exact host such as "tools.example.com"
+// or a wildcard such as "*.example.com", which matches any subdomain but not
+// example.com itself. Exact matches win, then the longest wildcard, then Default.
+type VirtualHosts struct {
+	hosts     map[string]Handler
+	wildcards map[string]Handler
+	//Default handles hosts no pattern matches
+	Default Handler
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		hosts:     make(map[string]Handler),
+		wildcards: make(map[string]Handler),
+		Default:   notFound,
+	}
+}
+
+// Handle registers h for requests whose host matches pattern. Ports are not
+// part of the match.
+func (v *VirtualHosts) Handle(pattern string, h Handler) {
+	pattern = normaliseHost(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		v.wildcards["."+suffix] = h
+		return
+	}
+	v.hosts[pattern] = h
+}
+
+// Serve is the VirtualHosts' Handler.
+func (v *VirtualHosts) Serve(w *response.Writer, req *request.Request) {
+	v.handler(req.Host)(w, req)
+}
+
+func (v *VirtualHosts) handler(host string) Handler {
+	host = normaliseHost(host)
+	if h, ok := v.hosts[host]; ok {
+		return h
+	}
+	best := ""
+	for suffix := range v.wildcards {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) && len(suffix) > len(best) {
+			best = suffix
+		}
+	}
+	if best != "" {
+		return v.wildcards[best]
+	}
+	return v.Default
+}
+
+// normaliseHost lowercases host and drops the trailing dot of a fully qualified name.
+func normaliseHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
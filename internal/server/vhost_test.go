@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	v := NewVirtualHosts()
+	v.Handle("tools.example.com", textHandler("tools"))
+	v.Handle("*.example.com", textHandler("wildcard"))
+	v.Handle("*.api.example.com", textHandler("api"))
+	v.Default = textHandler("default")
+
+	m := NewMux()
+	m.Handle("GET", "/", v.Serve)
+
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"tools.example.com", "tools"},
+		{"TOOLS.example.com:8080", "tools"},
+		{"tools.example.com.", "tools"},
+		{"docs.example.com", "wildcard"},
+		{"a.b.example.com", "wildcard"},
+		{"v1.api.example.com", "api"},
+		{"example.com", "default"},
+		{"notexample.com", "default"},
+		{"localhost", "default"},
+	}
+	for _, tc := range cases {
+		out := serveMux(t, m, "GET / HTTP/1.1\r\nHost: "+tc.host+"\r\n\r\n")
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+tc.want), "%s: %s", tc.host, out)
+	}
+
+	//Test: Without a Default, unknown hosts get 404
+	v = NewVirtualHosts()
+	v.Handle("tools.example.com", textHandler("tools"))
+	m = NewMux()
+	m.Handle("GET", "/", v.Serve)
+	out := serveMux(t, m, "GET / HTTP/1.1\r\nHost: other.example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Key signs cookies with HashKey and, if BlockKey is set (16, 24 or 32 bytes),
+// also encrypts them with AES-GCM.
+type Key struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+var errInvalidCookie = errors.New("session cookie failed verification")
+
+var encoding = base64.RawURLEncoding
+
+// codec encodes with the first key and accepts any of them, so keys can be
+// rotated by prepending the new one and dropping the old one later.
+type codec struct {
+	keys []Key
+}
+
+func newCodec(keys []Key) (*codec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("session: at least one key is required")
+	}
+	for i, k := range keys {
+		if len(k.HashKey) < 32 {
+			return nil, fmt.Errorf("session: hash key %d must be at least 32 bytes", i)
+		}
+		if k.BlockKey != nil {
+			_, err := aes.NewCipher(k.BlockKey)
+			if err != nil {
+				return nil, fmt.Errorf("session: block key %d: %v", i, err)
+			}
+		}
+	}
+	return &codec{keys: keys}, nil
+}
+
+// encode returns payload.signature, with the payload encrypted first if the key has a BlockKey.
+// The cookie name is part of the signature so values can't be swapped between cookies.
+func (c *codec) encode(name string, plain []byte) (string, error) {
+	key := c.keys[0]
+	payload := plain
+	if key.BlockKey != nil {
+		var err error
+		payload, err = encrypt(key.BlockKey, plain)
+		if err != nil {
+			return "", err
+		}
+	}
+	encoded := encoding.EncodeToString(payload)
+	return encoded + "." + encoding.EncodeToString(sign(key.HashKey, name, encoded)), nil
+}
+
+func (c *codec) decode(name, value string) ([]byte, error) {
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errInvalidCookie
+	}
+	mac, err := encoding.DecodeString(sig)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	payload, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	for _, key := range c.keys {
+		if !hmac.Equal(mac, sign(key.HashKey, name, encoded)) {
+			continue
+		}
+		if key.BlockKey == nil {
+			return payload, nil
+		}
+		return decrypt(key.BlockKey, payload)
+	}
+	return nil, errInvalidCookie
+}
+
+func sign(hashKey []byte, name, encoded string) []byte {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(name + "|" + encoded))
+	return mac.Sum(nil)
+}
+
+func encrypt(blockKey, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(blockKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func decrypt(blockKey, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(blockKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errInvalidCookie
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	return plain, nil
+}
@@ -0,0 +1,277 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/cookie"
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/JA50N14/httpfromtcp/internal/server"
+)
+
+// maxCookieSize is the smallest per-cookie limit browsers are required to support.
+const maxCookieSize = 4096
+
+type Options struct {
+	CookieName string
+	//Keys sign (and optionally encrypt) the cookie; the first one is used for new cookies
+	Keys []Key
+	//IdleTimeout ends a session that hasn't been used for this long
+	IdleTimeout time.Duration
+	//AbsoluteTimeout ends a session this long after it was created, however active it is
+	AbsoluteTimeout time.Duration
+	//Store keeps session data on the server; when nil the data lives in the cookie
+	Store Store
+
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite cookie.SameSite
+}
+
+type Session struct {
+	id       string
+	values   map[string]string
+	created  time.Time
+	lastSeen time.Time
+
+	isNew       bool
+	dirty       bool
+	destroyed   bool
+	regenerated string //old store ID to delete after Regenerate
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.dirty = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+	s.dirty = true
+}
+
+// Destroy clears the session and expires its cookie.
+func (s *Session) Destroy() {
+	s.values = map[string]string{}
+	s.destroyed = true
+}
+
+// Regenerate gives the session a new ID, which should be done whenever the
+// user's privileges change (e.g. at login) to prevent session fixation.
+func (s *Session) Regenerate() {
+	if s.regenerated == "" && !s.isNew {
+		s.regenerated = s.id
+	}
+	s.id = ""
+	s.isNew = true
+	s.dirty = true
+}
+
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+type Manager struct {
+	opts  Options
+	codec *codec
+}
+
+// sessionKey holds a request's session in its context; each manager has its own.
+type sessionKey struct {
+	m *Manager
+}
+
+func NewManager(opts Options) (*Manager, error) {
+	c, err := newCodec(opts.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 30 * time.Minute
+	}
+	if opts.AbsoluteTimeout <= 0 {
+		opts.AbsoluteTimeout = 24 * time.Hour
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	return &Manager{
+		opts:  opts,
+		codec: c,
+	}, nil
+}
+
+// Middleware loads the session before next runs and saves it just before the
+// response headers are written.
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		sess := m.load(req)
+		req = req.WithContext(context.WithValue(req.Context(), sessionKey{m}, sess))
+
+		w.OnWriteHeaders(func(_ headers.Headers) {
+			err := m.save(w, sess)
+			if err != nil {
+				log.Printf("error saving session: %v", err)
+			}
+		})
+		next(w, req)
+	}
+}
+
+// Get returns the session for a request handled inside Middleware, or nil.
+func (m *Manager) Get(req *request.Request) *Session {
+	sess, _ := req.Context().Value(sessionKey{m}).(*Session)
+	return sess
+}
+
+type cookiePayload struct {
+	Values   map[string]string `json:"v"`
+	Created  int64             `json:"c"`
+	LastSeen int64             `json:"l"`
+}
+
+func (m *Manager) load(req *request.Request) *Session {
+	now := time.Now()
+	fresh := &Session{
+		values:   map[string]string{},
+		created:  now,
+		lastSeen: now,
+		isNew:    true,
+	}
+
+	c, ok := req.Cookie(m.opts.CookieName)
+	if !ok {
+		return fresh
+	}
+	plain, err := m.codec.decode(m.opts.CookieName, c.Value)
+	if err != nil {
+		return fresh
+	}
+
+	sess := &Session{}
+	if m.opts.Store != nil {
+		rec, ok, err := m.opts.Store.Load(string(plain))
+		if err != nil {
+			log.Printf("error loading session: %v", err)
+		}
+		if !ok || err != nil {
+			return fresh
+		}
+		sess.id = string(plain)
+		sess.values = rec.Values
+		sess.created = rec.Created
+		sess.lastSeen = rec.LastSeen
+	} else {
+		var p cookiePayload
+		err := json.Unmarshal(plain, &p)
+		if err != nil {
+			return fresh
+		}
+		sess.values = p.Values
+		sess.created = time.Unix(p.Created, 0)
+		sess.lastSeen = time.Unix(p.LastSeen, 0)
+	}
+	if sess.values == nil {
+		sess.values = map[string]string{}
+	}
+	if now.Sub(sess.lastSeen) > m.opts.IdleTimeout || now.Sub(sess.created) > m.opts.AbsoluteTimeout {
+		if m.opts.Store != nil {
+			m.opts.Store.Delete(sess.id)
+		}
+		return fresh
+	}
+	return sess
+}
+
+func (m *Manager) save(w *response.Writer, sess *Session) error {
+	c := &cookie.Cookie{
+		Name:     m.opts.CookieName,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	}
+	if sess.destroyed {
+		if m.opts.Store != nil && sess.id != "" {
+			m.opts.Store.Delete(sess.id)
+		}
+		if sess.isNew {
+			return nil
+		}
+		c.MaxAge = -1
+		return w.SetCookie(c)
+	}
+	//don't hand out sessions to visitors that never stored anything
+	if sess.isNew && !sess.dirty {
+		return nil
+	}
+
+	now := time.Now()
+	sess.lastSeen = now
+	remaining := m.opts.AbsoluteTimeout - now.Sub(sess.created)
+	c.MaxAge = max(int(remaining.Seconds()), 1)
+
+	var plain []byte
+	if m.opts.Store != nil {
+		if sess.regenerated != "" {
+			m.opts.Store.Delete(sess.regenerated)
+		}
+		if sess.id == "" {
+			id, err := newID()
+			if err != nil {
+				return err
+			}
+			sess.id = id
+		}
+		err := m.opts.Store.Save(sess.id, Record{Values: sess.values, Created: sess.created, LastSeen: now}, min(m.opts.IdleTimeout, remaining))
+		if err != nil {
+			return err
+		}
+		if !sess.isNew {
+			//the store tracks activity, the cookie doesn't need to be re-sent
+			return nil
+		}
+		plain = []byte(sess.id)
+	} else {
+		var err error
+		plain, err = json.Marshal(cookiePayload{Values: sess.values, Created: sess.created.Unix(), LastSeen: now.Unix()})
+		if err != nil {
+			return err
+		}
+	}
+
+	value, err := m.codec.encode(m.opts.CookieName, plain)
+	if err != nil {
+		return err
+	}
+	c.Value = value
+	if len(c.String()) > maxCookieSize {
+		return fmt.Errorf("session cookie is %d bytes, more than the %d browsers accept; use a Store", len(c.String()), maxCookieSize)
+	}
+	return w.SetCookie(c)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+type wrapKey struct{}
+
+var setCookieRe = regexp.MustCompile(`set-cookie: session=([^;]*);`)
+
+// roundTrip runs handler behind the manager for a request carrying cookieValue
+// and returns the session cookie value that was set, if any.
+func roundTrip(t *testing.T, m *Manager, cookieValue string, handler func(s *Session)) string {
+	data := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if cookieValue != "" {
+		data += "Cookie: other=1; session=" + cookieValue + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(data + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	m.Middleware(func(w *response.Writer, req *request.Request) {
+		//middleware further in, such as tracing, may swap the request for a copy
+		req = req.WithContext(context.WithValue(req.Context(), wrapKey{}, 1))
+		handler(m.Get(req))
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})(w, req)
+
+	match := setCookieRe.FindStringSubmatch(buf.String())
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func testKey(b byte) Key {
+	return Key{HashKey: bytes.Repeat([]byte{b}, 32)}
+}
+
+func TestCookieSession(t *testing.T) {
+	key := testKey(1)
+	key.BlockKey = bytes.Repeat([]byte{2}, 32)
+	m, err := NewManager(Options{Keys: []Key{key}})
+	require.NoError(t, err)
+
+	//Test: Anonymous visitors get no cookie
+	assert.Equal(t, "", roundTrip(t, m, "", func(s *Session) {}))
+
+	//Test: Stored values come back and are encrypted in the cookie
+	value := roundTrip(t, m, "", func(s *Session) { s.Set("user", "jason") })
+	require.NotEmpty(t, value)
+	assert.NotContains(t, value, "jason")
+	roundTrip(t, m, value, func(s *Session) {
+		v, ok := s.Get("user")
+		assert.True(t, ok)
+		assert.Equal(t, "jason", v)
+		assert.False(t, s.IsNew())
+	})
+
+	//Test: Tampered cookies start a fresh session
+	roundTrip(t, m, "x"+value, func(s *Session) {
+		assert.True(t, s.IsNew())
+	})
+
+	//Test: Old cookies still verify after key rotation
+	rotated, err := NewManager(Options{Keys: []Key{testKey(3), key}})
+	require.NoError(t, err)
+	roundTrip(t, rotated, value, func(s *Session) {
+		v, _ := s.Get("user")
+		assert.Equal(t, "jason", v)
+	})
+
+	//Test: Unknown keys are rejected
+	other, err := NewManager(Options{Keys: []Key{testKey(3)}})
+	require.NoError(t, err)
+	roundTrip(t, other, value, func(s *Session) {
+		assert.True(t, s.IsNew())
+	})
+}
+
+func TestStoreSession(t *testing.T) {
+	store := NewMemoryStore()
+	m, err := NewManager(Options{Keys: []Key{testKey(1)}, Store: store, IdleTimeout: time.Hour})
+	require.NoError(t, err)
+
+	value := roundTrip(t, m, "", func(s *Session) { s.Set("cart", "3 items") })
+	require.NotEmpty(t, value)
+
+	//Test: The cookie is not re-sent for existing store sessions
+	resent := roundTrip(t, m, value, func(s *Session) {
+		v, _ := s.Get("cart")
+		assert.Equal(t, "3 items", v)
+	})
+	assert.Equal(t, "", resent)
+
+	//Test: Regenerate issues a new ID and drops the old one
+	regenerated := roundTrip(t, m, value, func(s *Session) { s.Regenerate() })
+	require.NotEmpty(t, regenerated)
+	assert.NotEqual(t, value, regenerated)
+	roundTrip(t, m, value, func(s *Session) { assert.True(t, s.IsNew()) })
+
+	//Test: Destroy removes the session
+	roundTrip(t, m, regenerated, func(s *Session) { s.Destroy() })
+	roundTrip(t, m, regenerated, func(s *Session) { assert.True(t, s.IsNew()) })
+}
+
+func TestConcurrentStoreSession(t *testing.T) {
+	m, err := NewManager(Options{Keys: []Key{testKey(1)}, Store: NewMemoryStore(), IdleTimeout: time.Hour})
+	require.NoError(t, err)
+	value := roundTrip(t, m, "", func(s *Session) { s.Set("visits", "0") })
+	require.NotEmpty(t, value)
+
+	//Test: Parallel requests on one session don't share its values
+	const requests = 8
+	var loaded, wg sync.WaitGroup
+	loaded.Add(requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roundTrip(t, m, value, func(s *Session) {
+				//every request has its session before any of them changes it
+				loaded.Done()
+				loaded.Wait()
+				s.Set("k"+strconv.Itoa(i), "v")
+			})
+		}()
+	}
+	wg.Wait()
+	roundTrip(t, m, value, func(s *Session) {
+		v, _ := s.Get("visits")
+		assert.Equal(t, "0", v)
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	m, err := NewManager(Options{Keys: []Key{testKey(1)}, IdleTimeout: time.Second})
+	require.NoError(t, err)
+	value := roundTrip(t, m, "", func(s *Session) { s.Set("a", "b") })
+
+	//Test: Idle sessions expire
+	time.Sleep(2100 * time.Millisecond)
+	roundTrip(t, m, value, func(s *Session) { assert.True(t, s.IsNew()) })
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Record is what a Store keeps for one session.
+type Record struct {
+	Values   map[string]string
+	Created  time.Time
+	LastSeen time.Time
+}
+
+// Store keeps session data on the server, so the cookie only carries the signed
+// session ID. Load returns ok=false for unknown or expired IDs. Requests on the
+// same session run at once, so a Store must not hand out or keep a Values map
+// it shares with another caller.
+type Store interface {
+	Load(id string) (rec Record, ok bool, err error)
+	Save(id string, rec Record, ttl time.Duration) error
+	Delete(id string) error
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+}
+
+type memoryRecord struct {
+	rec     Record
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]memoryRecord{},
+	}
+}
+
+func (m *MemoryStore) Load(id string) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[id]
+	if !ok {
+		return Record{}, false, nil
+	}
+	if time.Now().After(r.expires) {
+		delete(m.records, id)
+		return Record{}, false, nil
+	}
+	//each request gets its own copy of the values to change
+	rec := r.rec
+	rec.Values = maps.Clone(rec.Values)
+	return rec, true, nil
+}
+
+func (m *MemoryStore) Save(id string, rec Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	rec.Values = maps.Clone(rec.Values)
+	m.records[id] = memoryRecord{rec: rec, expires: now.Add(ttl)}
+
+	//sweep expired records now and then so abandoned sessions don't pile up
+	if len(m.records)%128 == 0 {
+		for k, r := range m.records {
+			if now.After(r.expires) {
+				delete(m.records, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
@@ -0,0 +1,200 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+const DefaultHeartbeat = 15 * time.Second
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Stream writes a text/event-stream response as chunked body.
+// It is safe to call Send from multiple goroutines.
+type Stream struct {
+	mu          sync.Mutex
+	w           *response.Writer
+	lastEventID string
+	done        chan struct{}
+	closeOnce   sync.Once
+	closed      bool
+	stop        chan struct{}
+}
+
+// NewStream writes the status line and event-stream headers and starts sending
+// heartbeat comments every heartbeat interval. A heartbeat of 0 disables them.
+// For HEAD requests only the headers go out and Done is already closed.
+func NewStream(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Stream, error) {
+	err := w.WriteStatusLine(response.StatusCodeSuccess)
+	if err != nil {
+		return nil, err
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Content-Type", "text/event-stream")
+	h.Override("Cache-Control", "no-cache")
+	h.Override("Transfer-Encoding", "chunked")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:    w,
+		done: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+	if req != nil {
+		s.lastEventID, _ = req.Headers.Get("Last-Event-ID")
+		if req.RequestLine.Method == "HEAD" {
+			//there is no body to stream, so let the handler finish right away
+			s.disconnect()
+			return s, nil
+		}
+	}
+	if req != nil {
+		go s.watch(req.Context())
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+// LastEventID is the Last-Event-ID sent by a reconnecting client, or "" on a fresh connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the client has gone away, the request's context is
+// cancelled or the stream was closed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Send(e Event) error {
+	data, err := formatEvent(e)
+	if err != nil {
+		return err
+	}
+	return s.write(data)
+}
+
+// Comment sends a comment line, which clients ignore but which keeps the connection alive.
+func (s *Stream) Comment(text string) error {
+	if strings.ContainsAny(text, "\r\n") {
+		return fmt.Errorf("sse comment must not contain line breaks")
+	}
+	return s.write([]byte(": " + text + "\n\n"))
+}
+
+// Close stops the heartbeat and terminates the chunked body.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	defer s.disconnect()
+
+	_, err := s.w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+	err = s.w.WriteTrailers(headers.NewHeaders())
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *Stream) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("sse stream is closed")
+	}
+	_, err := s.w.WriteChunkedBody(data)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.disconnect()
+		return fmt.Errorf("client disconnected: %v", err)
+	}
+	return nil
+}
+
+func (s *Stream) disconnect() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// watch ends the stream when the server cancels the request, which it does
+// as soon as it sees the client hang up, so Done doesn't wait for a failed write.
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.disconnect()
+	case <-s.done:
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		case <-s.stop:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func formatEvent(e Event) ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("invalid sse event id: %q", e.ID)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("invalid sse event name: %q", e.Event)
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	//every line of the payload needs its own data field
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,88 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+)
+
+func TestFormatEvent(t *testing.T) {
+	//Test: All fields with multi-line data
+	data, err := formatEvent(Event{ID: "42", Event: "update", Data: "line one\r\nline two\nline three", Retry: 3 * time.Second})
+	require.NoError(t, err)
+	assert.Equal(t, "id: 42\nevent: update\nretry: 3000\ndata: line one\ndata: line two\ndata: line three\n\n", string(data))
+
+	//Test: Data only
+	data, err = formatEvent(Event{Data: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n\n", string(data))
+
+	//Test: Invalid id
+	_, err = formatEvent(Event{ID: "4\n2", Data: "hello"})
+	require.Error(t, err)
+
+	//Test: Invalid event name
+	_, err = formatEvent(Event{Event: "up\rdate", Data: "hello"})
+	require.Error(t, err)
+}
+
+func TestStream(t *testing.T) {
+	reader := strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost:42069\r\nLast-Event-ID: 7\r\n\r\n")
+	req, err := request.RequestFromReader(reader)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	s, err := NewStream(response.NewWriter(&buf), req, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "7", s.LastEventID())
+
+	require.NoError(t, s.Send(Event{ID: "8", Data: "hi"}))
+	require.NoError(t, s.Close())
+	<-s.Done()
+	require.Error(t, s.Send(Event{Data: "too late"}))
+
+	out := buf.String()
+	assert.Contains(t, out, "content-type: text/event-stream\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n10\r\nid: 8\ndata: hi\n\n\r\n0\r\n\r\n"))
+}
+
+func TestStreamDone(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, client)
+	s, err := NewStream(response.NewWriter(server), req, 0)
+	require.NoError(t, err)
+
+	//Test: Done waits while the client is connected and nothing is sent
+	select {
+	case <-s.Done():
+		t.Fatal("Done closed while the client is connected")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	//Test: The server cancelling the request on hang-up closes Done without a write
+	client.Close()
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the request was cancelled")
+	}
+}
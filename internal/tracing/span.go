@@ -0,0 +1,290 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/JA50N14/httpfromtcp/internal/server"
+)
+
+// SpanKind uses the OTLP numbering.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// Span is one timed operation in a trace. Its fields must only be read once
+// it has ended, which is when exporters get it.
+type Span struct {
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]any
+	Error         bool
+	StatusMessage string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SpanContext returns the span's identity, or the zero SpanContext for the
+// span of a context that has none.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// SetAttribute records a string, bool, int or float64 attribute.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Attributes[key] = value
+	}
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Error = true
+		s.StatusMessage = message
+	}
+}
+
+// Finish ends the span and hands it to the exporter if it is sampled. Only
+// the first call counts.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.tracer != nil && s.Context.Sampled() {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// SpanFromContext returns the span in ctx, or nil. A nil *Span is safe to use
+// and does nothing.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// ContextWithRemoteSpanContext makes sc, received from a caller, the parent of
+// the next span started from ctx.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start starts a span under the span in ctx, using that span's tracer. Without
+// one it returns ctx and a nil span, so libraries can trace unconditionally.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil || parent.tracer == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+const (
+	exportQueueSize = 2048
+	exportBatchSize = 512
+	exportInterval  = 2 * time.Second
+	exportTimeout   = 10 * time.Second
+)
+
+// Tracer starts spans and exports the sampled ones in batches from a
+// background goroutine. Spans that arrive while the queue is full are dropped.
+type Tracer struct {
+	exporter Exporter
+	queue    chan *Span
+	flush    chan chan struct{}
+	done     chan struct{}
+	closing  sync.Once
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan *Span, exportQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span. Its parent is the span in ctx, or else a remote span
+// context put there by ContextWithRemoteSpanContext; without either the span
+// begins a new, sampled trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent, _ = ctx.Value(remoteKey{}).(SpanContext)
+	}
+	if parent.IsValid() {
+		s.Context = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, TraceState: parent.TraceState}
+		s.Parent = parent.SpanID
+	} else {
+		s.Context = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	s.Context.SpanID = newSpanID()
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	case <-t.done:
+	default:
+	}
+}
+
+// Shutdown exports the spans still queued and shuts the exporter down. Spans
+// that end afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.closing.Do(func() {
+		ack := make(chan struct{})
+		select {
+		case t.flush <- ack:
+			<-ack
+		case <-ctx.Done():
+		}
+		close(t.done)
+	})
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.done:
+			//Shutdown gave up waiting for us
+			t.export(batch)
+			return
+		case ack := <-t.flush:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			t.export(batch)
+			close(ack)
+			return
+		}
+		t.export(batch)
+		batch = nil
+	}
+}
+
+func (t *Tracer) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	err := t.exporter.ExportSpans(ctx, batch)
+	if err != nil {
+		log.Printf("error exporting %d spans: %v", len(batch), err)
+	}
+}
+
+// Middleware starts a server span for every request, continuing the trace the
+// caller sent in traceparent and tracestate if they are valid. Handlers reach
+// the span through the request context, and responses of 500 and up mark it
+// as failed.
+func Middleware(t *Tracer) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ctx := req.Context()
+			if sc, ok := Extract(req.Headers); ok {
+				ctx = ContextWithRemoteSpanContext(ctx, sc)
+			}
+			ctx, span := t.Start(ctx, req.RequestLine.Method, SpanKindServer)
+			span.SetAttribute("http.request.method", req.RequestLine.Method)
+			span.SetAttribute("url.path", req.Target.Path)
+			span.SetAttribute("server.address", req.Host)
+			span.SetAttribute("network.protocol.version", req.RequestLine.HttpVersion)
+			if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+				span.SetAttribute("client.address", host)
+			}
+			if ua, ok := req.Headers.Get("User-Agent"); ok {
+				span.SetAttribute("user_agent.original", ua)
+			}
+			//deferred so that a panicking handler still ends its span
+			defer func() {
+				status := w.StatusCode()
+				if status != 0 {
+					span.SetAttribute("http.response.status_code", int(status))
+				}
+				if status == 0 || status >= 500 {
+					span.SetError("")
+				}
+				span.Finish()
+			}()
+			next(w, req.WithContext(ctx))
+		}
+	}
+}
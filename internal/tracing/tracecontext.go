@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// FlagSampled is the trace-flags bit saying the caller may have recorded the trace.
+const FlagSampled byte = 0x01
+
+// SpanContext is what crosses process boundaries in the W3C Trace Context
+// headers: traceparent carries the IDs and flags, tracestate the vendor data.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent value. Versions after 00 are read as
+// far as 00 defines, as the spec asks, and the all-zero IDs are invalid.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	//version-traceid-parentid-flags is 2+1+32+1+16+1+2 characters
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, errInvalidTraceparent
+	}
+	version, ok := decodeHex(value[0:2])
+	if !ok || version[0] == 0xff || version[0] == 0 && len(value) != 55 || len(value) > 55 && value[55] != '-' {
+		return sc, errInvalidTraceparent
+	}
+	traceID, ok1 := decodeHex(value[3:35])
+	spanID, ok2 := decodeHex(value[36:52])
+	flags, ok3 := decodeHex(value[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, errInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, errInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex only takes lowercase hex, which is all traceparent allows.
+func decodeHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// maxTracestateMembers is the most list-members tracestate may carry.
+const maxTracestateMembers = 32
+
+// ParseTracestate validates a tracestate value and returns it with empty
+// members dropped. A malformed value is an error, and the caller should then
+// not propagate it at all.
+func ParseTracestate(value string) (string, error) {
+	var members []string
+	seen := make(map[string]bool)
+	for _, member := range strings.Split(value, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || !validTracestateKey(key) || !validTracestateValue(val) {
+			return "", fmt.Errorf("invalid tracestate member: %q", member)
+		}
+		if seen[key] {
+			return "", fmt.Errorf("duplicate tracestate key: %q", key)
+		}
+		seen[key] = true
+		members = append(members, member)
+	}
+	if len(members) > maxTracestateMembers {
+		return "", fmt.Errorf("tracestate has %d members, the limit is %d", len(members), maxTracestateMembers)
+	}
+	return strings.Join(members, ","), nil
+}
+
+// validTracestateKey checks simple-key or tenant-id "@" system-id.
+func validTracestateKey(key string) bool {
+	tenant, system, multi := strings.Cut(key, "@")
+	if !multi {
+		return len(key) <= 256 && isKeyPart(key, true)
+	}
+	return len(tenant) <= 241 && isKeyPart(tenant, false) && len(system) <= 14 && isKeyPart(system, true)
+}
+
+// isKeyPart checks lcalpha or, for a tenant-id, DIGIT first, then lcalpha,
+// DIGIT, "_", "-", "*" or "/".
+func isKeyPart(s string, alphaFirst bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		lower := c >= 'a' && c <= 'z'
+		digit := c >= '0' && c <= '9'
+		if i == 0 && !(lower || digit && !alphaFirst) {
+			return false
+		}
+		if !lower && !digit && c != '_' && c != '-' && c != '*' && c != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+// validTracestateValue checks for up to 256 printable ASCII characters other
+// than "," and "=", not ending in a space.
+func validTracestateValue(val string) bool {
+	if val == "" || len(val) > 256 || val[len(val)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract reads the span context a caller sent in h. ok is false without a
+// valid traceparent; an invalid tracestate is dropped on its own.
+func Extract(h headers.Headers) (sc SpanContext, ok bool) {
+	traceparent, found := h.Get("traceparent")
+	if !found {
+		return sc, false
+	}
+	sc, err := ParseTraceparent(strings.TrimSpace(traceparent))
+	if err != nil {
+		return sc, false
+	}
+	if tracestate, found := h.Get("tracestate"); found {
+		sc.TraceState, _ = ParseTracestate(tracestate)
+	}
+	return sc, true
+}
+
+// Inject writes the span context of the span in ctx to h for an outgoing
+// request. It does nothing if ctx carries no span.
+func Inject(ctx context.Context, h headers.Headers) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Override("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Override("tracestate", sc.TraceState)
+	} else {
+		h.Remove("tracestate")
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
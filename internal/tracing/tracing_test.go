@@ -0,0 +1,273 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JA50N14/httpfromtcp/internal/headers"
+	"github.com/JA50N14/httpfromtcp/internal/request"
+	"github.com/JA50N14/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder keeps exported spans for inspection.
+type recorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (r *recorder) ExportSpans(ctx context.Context, spans []*Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recorder) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	//Test: Valid version 00
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	//Test: A later version may append fields
+	sc, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-what-the-future-holds")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	//Test: Empty members and surrounding whitespace are dropped
+	ts, err := ParseTracestate("congo=t61rcWkgMzE, ,rojo=00f067aa0ba902b7 ,tenant@vendor=x")
+	require.NoError(t, err)
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7,tenant@vendor=x", ts)
+
+	//Test: Malformed members, duplicates and too many members
+	for _, value := range []string{
+		"Congo=x",
+		"congo",
+		"congo=",
+		"congo=a=b",
+		"congo=x,congo=y",
+		"1abc=x",
+		"t@1vendor=x",
+		strings.Repeat("a", 257) + "=x",
+	} {
+		_, err := ParseTracestate(value)
+		assert.Error(t, err, value)
+	}
+	members := make([]string, 33)
+	for i := range members {
+		members[i] = "k" + strings.Repeat("a", i) + "=v"
+	}
+	_, err = ParseTracestate(strings.Join(members, ","))
+	assert.Error(t, err)
+	_, err = ParseTracestate(strings.Join(members[:32], ","))
+	assert.NoError(t, err)
+}
+
+func serve(t *testing.T, tracer *Tracer, raw string, handler func(w *response.Writer, req *request.Request)) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:51000"
+	Middleware(tracer)(handler)(response.NewWriter(&bytes.Buffer{}), req)
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec)
+	outgoing := headers.NewHeaders()
+
+	//Test: The server span continues the caller's trace, and a client span
+	//started in the handler is its child and is what gets propagated
+	serve(t, tracer, "GET /proxy HTTP/1.1\r\nHost: localhost\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n"+
+		"tracestate: congo=t61rcWkgMzE\r\n\r\n",
+		func(w *response.Writer, req *request.Request) {
+			ctx, span := Start(req.Context(), "GET", SpanKindClient)
+			Inject(ctx, outgoing)
+			span.Finish()
+			w.WriteStatusLine(response.StatusCodeSuccess)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		})
+
+	//Test: Without a traceparent a new trace starts, and a 500 is an error
+	serve(t, tracer, "GET /fail HTTP/1.1\r\nHost: localhost\r\ntraceparent: garbage\r\n\r\n",
+		func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusCodeInternalServerError)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		})
+
+	//Test: An unsampled trace is propagated but not exported
+	serve(t, tracer, "GET / HTTP/1.1\r\nHost: localhost\r\n"+
+		"traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00\r\n\r\n",
+		func(w *response.Writer, req *request.Request) {
+			assert.False(t, SpanFromContext(req.Context()).SpanContext().Sampled())
+		})
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	require.Len(t, rec.spans, 3)
+	client, server, failed := rec.spans[0], rec.spans[1], rec.spans[2]
+
+	assert.Equal(t, SpanKindServer, server.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.String())
+	assert.Equal(t, "congo=t61rcWkgMzE", server.Context.TraceState)
+	assert.Equal(t, "/proxy", server.Attributes["url.path"])
+	assert.Equal(t, "192.0.2.1", server.Attributes["client.address"])
+	assert.Equal(t, 200, server.Attributes["http.response.status_code"])
+	assert.False(t, server.Error)
+
+	assert.Equal(t, SpanKindClient, client.Kind)
+	assert.Equal(t, server.Context.TraceID, client.Context.TraceID)
+	assert.Equal(t, server.Context.SpanID, client.Parent)
+	traceparent, _ := outgoing.Get("traceparent")
+	assert.Equal(t, client.Context.Traceparent(), traceparent)
+	tracestate, _ := outgoing.Get("tracestate")
+	assert.Equal(t, "congo=t61rcWkgMzE", tracestate)
+
+	assert.True(t, failed.Context.IsValid())
+	assert.NotEqual(t, server.Context.TraceID, failed.Context.TraceID)
+	assert.False(t, failed.Parent.IsValid())
+	assert.True(t, failed.Error)
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	//Test: Libraries can trace without a tracer configured
+	ctx, span := Start(context.Background(), "work", SpanKindInternal)
+	assert.Nil(t, span)
+	span.SetAttribute("k", "v")
+	span.SetError("boom")
+	span.Finish()
+	h := headers.NewHeaders()
+	Inject(ctx, h)
+	_, ok := h.Get("traceparent")
+	assert.False(t, ok)
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(NewStdoutExporter(&out))
+	_, span := tracer.Start(context.Background(), "work", SpanKindInternal)
+	span.SetAttribute("items", 3)
+	span.SetError("boom")
+	span.Finish()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "work", record["name"])
+	assert.Equal(t, "internal", record["kind"])
+	assert.Equal(t, span.Context.TraceID.String(), record["trace_id"])
+	assert.NotContains(t, record, "parent_span_id")
+	assert.Equal(t, map[string]any{"items": float64(3)}, record["attributes"])
+	assert.Equal(t, true, record["error"])
+	assert.Equal(t, "boom", record["status_message"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan *request.Request, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		received <- req
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	}()
+
+	exporter := &OTLPExporter{Endpoint: "http://" + listener.Addr().String() + "/v1/traces", ServiceName: "test"}
+	tracer := NewTracer(exporter)
+	ctx, parent := tracer.Start(context.Background(), "GET", SpanKindServer)
+	_, child := tracer.Start(ctx, "query", SpanKindClient)
+	child.SetAttribute("db.rows", 2)
+	child.SetError("timeout")
+	child.Finish()
+	parent.Finish()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	req := <-received
+	assert.Equal(t, "POST", req.RequestLine.Method)
+	assert.Equal(t, "/v1/traces", req.Target.Path)
+	contentType, _ := req.Headers.Get("Content-Type")
+	assert.Equal(t, "application/json", contentType)
+
+	var body struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value struct{ StringValue string }
+				}
+			}
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string
+					SpanID            string
+					ParentSpanID      string
+					Kind              int
+					StartTimeUnixNano string
+					Attributes        []struct {
+						Key   string
+						Value struct{ IntValue string }
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(req.Body, &body))
+	require.Len(t, body.ResourceSpans, 1)
+	assert.Equal(t, "service.name", body.ResourceSpans[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "test", body.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, child.Context.SpanID.String(), spans[0].SpanID)
+	assert.Equal(t, parent.Context.SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, parent.Context.TraceID.String(), spans[0].TraceID)
+	assert.Equal(t, 3, spans[0].Kind)
+	assert.NotEmpty(t, spans[0].StartTimeUnixNano)
+	assert.Equal(t, "2", spans[0].Attributes[0].Value.IntValue)
+	assert.Equal(t, 2, spans[0].Status.Code)
+	assert.Equal(t, "timeout", spans[0].Status.Message)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.Equal(t, 0, spans[1].Status.Code)
+}